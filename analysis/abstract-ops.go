@@ -42,7 +42,12 @@ func emptyRes() stepRes {
 }
 
 // initRes returns the initial program state (i.e., PC is 0 and stack and memory are empty).
-func initRes() stepRes {
+// The initial storage is unknown unless a snapshot is given and the initial transient storage is given explicitly.
+func initRes(snapshot *StorageSnapshot, transient absStorage) stepRes {
+	storage := unknownStorage()
+	if snapshot != nil {
+		storage = initialStorage(snapshot)
+	}
 	return stepRes{
 		postStates: []pcAndSt{
 			{
@@ -52,6 +57,8 @@ func initRes() stepRes {
 					mem: absMem{
 						mem: vm.NewMemory(),
 					},
+					storage:   storage,
+					transient: transient,
 				},
 			},
 		},
@@ -205,7 +212,8 @@ func makeMemFn(indices ...int) memSizeFn {
 }
 
 // newAbsJumpTable creates an abstract jump table.
-func newAbsJumpTable(forPrefix bool) absJumpTable {
+// Environment values are abstracted according to the given environment.
+func newAbsJumpTable(forPrefix bool, environment Environment, cfg Config) absJumpTable {
 	opCreate := mayFailOp
 	opCall := mayFailOp
 	opCallCode := mayFailOp
@@ -213,25 +221,26 @@ func newAbsJumpTable(forPrefix bool) absJumpTable {
 	opStaticCall := mayFailOp
 	opCreate2 := mayFailOp
 	if forPrefix {
+		// Calls (and contract creations) may reenter the contract and modify its storage.
 		opCreate = absOp{
 			valid:   true,
 			memSize: makeMemFn(1, 2),
-			exec:    makePopPushTopFn(3, 1),
+			exec:    clobberingStorage(makePopPushTopFn(3, 1)),
 		}
 		opCall = absOp{
 			valid:   true,
 			memSize: makeMemFn(3, 4, 5, 6),
-			exec:    makePopPushMemTopFn(7, 1, 5, 6),
+			exec:    clobberingStorage(makePopPushMemTopFn(7, 1, 5, 6)),
 		}
 		opCallCode = absOp{
 			valid:   true,
 			memSize: makeMemFn(3, 4, 5, 6),
-			exec:    makePopPushMemTopFn(7, 1, 5, 6),
+			exec:    clobberingStorage(makePopPushMemTopFn(7, 1, 5, 6)),
 		}
 		opDelegateCall = absOp{
 			valid:   true,
 			memSize: makeMemFn(2, 3, 4, 5),
-			exec:    makePopPushMemTopFn(6, 1, 4, 5),
+			exec:    clobberingStorage(makePopPushMemTopFn(6, 1, 4, 5)),
 		}
 		opStaticCall = absOp{
 			valid:   true,
@@ -241,7 +250,7 @@ func newAbsJumpTable(forPrefix bool) absJumpTable {
 		opCreate2 = absOp{
 			valid:   true,
 			memSize: makeMemFn(1, 2),
-			exec:    makePopPushTopFn(4, 1),
+			exec:    clobberingStorage(makePopPushTopFn(4, 1)),
 		}
 	}
 	return absJumpTable{
@@ -259,12 +268,12 @@ func newAbsJumpTable(forPrefix bool) absJumpTable {
 		vm.EXP:        makeStackOp(2, 1),
 		vm.SIGNEXTEND: makeStackOp(2, 1),
 
-		vm.LT:     makeStackOp(2, 1),
-		vm.GT:     makeStackOp(2, 1),
+		vm.LT:     makeCmpOp(vm.LT, environment, cfg.EnvSourceSteps),
+		vm.GT:     makeCmpOp(vm.GT, environment, cfg.EnvSourceSteps),
 		vm.SLT:    makeStackOp(2, 1),
 		vm.SGT:    makeStackOp(2, 1),
-		vm.EQ:     makeStackOp(2, 1),
-		vm.ISZERO: makeStackOp(1, 1),
+		vm.EQ:     makeCmpOp(vm.EQ, environment, cfg.EnvSourceSteps),
+		vm.ISZERO: makeCmpOp(vm.ISZERO, environment, cfg.EnvSourceSteps),
 		vm.AND:    makeStackOp(2, 1),
 		vm.XOR:    makeStackOp(2, 1),
 		vm.OR:     makeStackOp(2, 1),
@@ -280,11 +289,11 @@ func newAbsJumpTable(forPrefix bool) absJumpTable {
 		vm.SHR: makeStackOp(2, 1),
 		vm.SAR: makeStackOp(2, 1),
 
-		vm.ADDRESS:      makePopPushTopOp(0, 1),
+		vm.ADDRESS:      makeEnvOp(environment.Address),
 		vm.BALANCE:      makePopPushTopOp(1, 1),
-		vm.ORIGIN:       makePopPushTopOp(0, 1),
-		vm.CALLER:       makePopPushTopOp(0, 1),
-		vm.CALLVALUE:    makePopPushTopOp(0, 1),
+		vm.ORIGIN:       makeEnvOp(environment.Origin),
+		vm.CALLER:       makeEnvOp(environment.Caller),
+		vm.CALLVALUE:    makeEnvOp(environment.CallValue),
 		vm.CALLDATALOAD: makePopPushTopOp(1, 1),
 		vm.CALLDATASIZE: makePopPushTopOp(0, 1),
 		vm.CALLDATACOPY: absOp{
@@ -298,7 +307,7 @@ func newAbsJumpTable(forPrefix bool) absJumpTable {
 			memSize: makeMemFn(0, 2),
 			exec:    opCodeCopy,
 		},
-		vm.GASPRICE:    makePopPushTopOp(0, 1),
+		vm.GASPRICE:    makeEnvOp(environment.GasPrice),
 		vm.EXTCODESIZE: makePopPushTopOp(1, 1),
 		vm.EXTCODECOPY: absOp{
 			valid:   true,
@@ -316,12 +325,15 @@ func newAbsJumpTable(forPrefix bool) absJumpTable {
 
 		vm.BLOCKHASH:   makePopPushTopOp(1, 1),
 		vm.COINBASE:    makePopPushTopOp(0, 1),
-		vm.TIMESTAMP:   makePopPushTopOp(0, 1),
-		vm.NUMBER:      makePopPushTopOp(0, 1),
+		vm.TIMESTAMP:   makeEnvOp(environment.Timestamp),
+		vm.NUMBER:      makeEnvOp(environment.Number),
 		vm.DIFFICULTY:  makePopPushTopOp(0, 1),
 		vm.GASLIMIT:    makePopPushTopOp(0, 1),
-		vm.CHAINID:     makePopPushTopOp(0, 1),
-		vm.SELFBALANCE: makePopPushTopOp(0, 1),
+		vm.CHAINID:     makeEnvOp(environment.ChainID),
+		vm.SELFBALANCE: makeEnvOp(environment.SelfBalance),
+		vm.BASEFEE:     makePopPushTopOp(0, 1),
+		vm.BLOBHASH:    makePopPushTopOp(1, 1),
+		vm.BLOBBASEFEE: makePopPushTopOp(0, 1),
 
 		vm.POP: makeStackOp(1, 0),
 
@@ -341,17 +353,25 @@ func newAbsJumpTable(forPrefix bool) absJumpTable {
 			exec:    opMstore8,
 		},
 
-		vm.SLOAD:  makePopPushTopOp(1, 1),
-		vm.SSTORE: makePopPushTopOp(2, 0),
+		vm.SLOAD:  fromExec(opSload),
+		vm.SSTORE: fromExec(opSstore),
+		vm.TLOAD:  fromExec(opTload),
+		vm.TSTORE: fromExec(opTstore),
+		vm.MCOPY: absOp{
+			valid:   true,
+			memSize: makeMemFn(0, 1, 2),
+			exec:    opMcopy,
+		},
 
 		vm.JUMP:  fromExec(opJump),
-		vm.JUMPI: fromExec(opJumpi),
+		vm.JUMPI: fromExec(makeOpJumpi(cfg.BackpropSteps)),
 
 		vm.PC:       fromExec(delegateConcStackOp),
 		vm.MSIZE:    fromExec(opMsize),
 		vm.GAS:      makePopPushTopOp(0, 1),
 		vm.JUMPDEST: noOpOp,
 
+		vm.PUSH0:  fromExec(delegateConcStackOp),
 		vm.PUSH1:  fromExec(delegateConcStackOp),
 		vm.PUSH2:  fromExec(delegateConcStackOp),
 		vm.PUSH3:  fromExec(delegateConcStackOp),
@@ -629,84 +649,88 @@ func opJump(env execEnv) (stepRes, error) {
 	}, nil
 }
 
-func opJumpi(env execEnv) (stepRes, error) {
-	stack, _ := env.unpack()
-	cond := stack.Back(1)
-	var alts []absState
-	if isTop(cond) {
-		ppc, exists := env.ppcMap.getPrevPC(*env.pc)
-		if !exists {
-			return failRes(InternalFail), nil
-		}
-
-		thenSt := env.st.withStackCopy()
-		// We check if the condition is boolean based on what opcodes where executed earlier.
-		isBooleanCond, _, _ := matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.ISZERO})
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.EQ})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.LT})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.GT})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.SLT})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.SGT})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.CALL})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.STATICCALL})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.DELEGATECALL})
-		}
-		if !isBooleanCond {
-			isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.CALLCODE})
-		}
-		if isBooleanCond {
-			thenStack := thenSt.stack.stack
-			thenCond := thenStack.Back(1)
-			thenCond.Set(big.NewInt(1))
-			refinedStack := backwardsRefineStack(thenStack, env.contract, env.ppcMap, ppc, MagicInt(16))
-			thenSt.stack.stack = refinedStack
-		}
+// makeOpJumpi returns a JUMPI operation that refines the stack at a branch with an unknown condition by inspecting
+// up to the given number of earlier instructions.
+func makeOpJumpi(maxBackpropSteps int) execFn {
+	return func(env execEnv) (stepRes, error) {
+		stack, _ := env.unpack()
+		cond := stack.Back(1)
+		var alts []absState
+		if isTop(cond) {
+			ppc, exists := env.ppcMap.getPrevPC(*env.pc)
+			if !exists {
+				return failRes(InternalFail), nil
+			}
 
-		elseSt := env.st.withStackCopy()
-		elseStack := elseSt.stack.stack
-		elseCond := elseStack.Back(1)
-		elseCond.Set(big.NewInt(0))
+			thenSt := env.st.withStackCopy()
+			// We check if the condition is boolean based on what opcodes where executed earlier.
+			isBooleanCond, _, _ := matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.ISZERO})
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.EQ})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.LT})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.GT})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.SLT})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.SGT})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.CALL})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.STATICCALL})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.DELEGATECALL})
+			}
+			if !isBooleanCond {
+				isBooleanCond, _, _ = matchesBackwards(env.contract, env.ppcMap, ppc, []vm.OpCode{vm.PUSH, vm.CALLCODE})
+			}
+			if isBooleanCond {
+				thenStack := thenSt.stack.stack
+				thenCond := thenStack.Back(1)
+				thenCond.Set(big.NewInt(1))
+				refinedStack := backwardsRefineStack(thenStack, env.contract, env.ppcMap, ppc, maxBackpropSteps)
+				thenSt.stack.stack = refinedStack
+			}
 
-		refinedStack := backwardsRefineStack(elseStack, env.contract, env.ppcMap, ppc, MagicInt(16))
-		elseSt.stack.stack = refinedStack
+			elseSt := env.st.withStackCopy()
+			elseStack := elseSt.stack.stack
+			elseCond := elseStack.Back(1)
+			elseCond.Set(big.NewInt(0))
 
-		alts = []absState{thenSt, elseSt}
-	} else {
-		alts = []absState{env.st.withStackCopy()}
-	}
+			refinedStack := backwardsRefineStack(elseStack, env.contract, env.ppcMap, ppc, maxBackpropSteps)
+			elseSt.stack.stack = refinedStack
 
-	var newStates []pcAndSt
-	for _, st := range alts {
-		altEnv := env.withSt(st).withPcCopy()
-		altDest, _ := altEnv.unpack()
-		if isTop(altDest.Peek()) {
-			return failRes(JumpToTopFail), nil
+			alts = []absState{thenSt, elseSt}
+		} else {
+			alts = []absState{env.st.withStackCopy()}
 		}
-		if err := execConc(altEnv); err == nil {
-			// We ignore states that would lead to an error (e.g., invalid jump destination).
-			postJmpSt := pcAndSt{
-				pc: *altEnv.pc, // The PC was already updated by the concrete execution.
-				st: altEnv.st,
+
+		var newStates []pcAndSt
+		for _, st := range alts {
+			altEnv := env.withSt(st).withPcCopy()
+			altDest, _ := altEnv.unpack()
+			if isTop(altDest.Peek()) {
+				return failRes(JumpToTopFail), nil
+			}
+			if err := execConc(altEnv); err == nil {
+				// We ignore states that would lead to an error (e.g., invalid jump destination).
+				postJmpSt := pcAndSt{
+					pc: *altEnv.pc, // The PC was already updated by the concrete execution.
+					st: altEnv.st,
+				}
+				newStates = append(newStates, postJmpSt)
 			}
-			newStates = append(newStates, postJmpSt)
 		}
+		return stepRes{postStates: newStates}, nil
 	}
-	return stepRes{postStates: newStates}, nil
 }
 
 func opMsize(env execEnv) (stepRes, error) {
@@ -757,16 +781,57 @@ func opExtCodeCopy(env execEnv) (stepRes, error) {
 func opCodeCopy(env execEnv) (stepRes, error) {
 	env2 := env.withStackCopy().withMemCopy().withPcCopy()
 	stack2, mem2 := env2.unpack()
-	memOffset, _, size := stack2.Pop(), stack2.Pop(), stack2.Pop()
-	if mem2.isTop {
+	memOffset, codeOffset, size := stack2.Back(0), stack2.Back(1), stack2.Back(2)
+	if mem2.isTop || isTop(memOffset) || isTop(size) || isTop(codeOffset) {
+		stack2.Pop()
+		stack2.Pop()
+		stack2.Pop()
+		if mem2.isTop {
+			return nextPcRes(env2), nil
+		}
+		if isTop(memOffset) || isTop(size) {
+			env2.st.mem = topMem()
+			return nextPcRes(env2), nil
+		}
+		mem2.set(memOffset.Uint64(), size.Uint64(), topBytes())
 		return nextPcRes(env2), nil
 	}
-	if isTop(memOffset) || isTop(size) {
-		env2.st.mem = topMem()
+	// The code is known so we can just copy it.
+	if err := execConc(env2); err != nil {
+		return emptyRes(), nil
+	}
+	return nextPcRes(env2), nil
+}
+
+func opMcopy(env execEnv) (stepRes, error) {
+	env2 := env.withStackCopy().withMemCopy().withPcCopy()
+	stack2, mem2 := env2.unpack()
+	dst, src, size := stack2.Back(0), stack2.Back(1), stack2.Back(2)
+	if mem2.isTop || isTop(dst) || isTop(src) || isTop(size) {
+		stack2.Pop()
+		stack2.Pop()
+		stack2.Pop()
+		if mem2.isTop || (!isTop(size) && size.Sign() == 0) {
+			return nextPcRes(env2), nil
+		}
+		if isTop(dst) || isTop(size) {
+			env2.st.mem = topMem()
+			return nextPcRes(env2), nil
+		}
+		mem2.set(dst.Uint64(), size.Uint64(), topBytes())
 		return nextPcRes(env2), nil
 	}
-	// We currently just store top values even though we could copy the contract's code.
-	mem2.set(memOffset.Uint64(), size.Uint64(), topBytes())
+	// The source is known so we can just copy it (including any top bytes).
+	if err := execConc(env2); err != nil {
+		// The copy may have been partially applied, so we start over and make memory top.
+		env3 := env.withStackCopy().withPcCopy()
+		stack3, _ := env3.unpack()
+		stack3.Pop()
+		stack3.Pop()
+		stack3.Pop()
+		env3.st.mem = topMem()
+		return nextPcRes(env3), nil
+	}
 	return nextPcRes(env2), nil
 }
 
@@ -19,9 +19,12 @@ package analysis
 
 // absState represents an abstract program state.
 type absState struct {
-	isBot bool
-	stack absStack
-	mem   absMem
+	isBot   bool
+	stack   absStack
+	mem     absMem
+	storage absStorage
+	// transient is the transient storage (EIP-1153), which is cleared at the end of every transaction.
+	transient absStorage
 }
 
 // withStack creates a new state with a copy of the stack.
@@ -30,8 +33,10 @@ func (s absState) withStackCopy() absState {
 		return botState()
 	}
 	return absState{
-		stack: s.stack.clone(),
-		mem:   s.mem,
+		stack:     s.stack.clone(),
+		mem:       s.mem,
+		storage:   s.storage,
+		transient: s.transient,
 	}
 }
 
@@ -41,8 +46,10 @@ func (s absState) withMemCopy() absState {
 		return botState()
 	}
 	return absState{
-		stack: s.stack,
-		mem:   s.mem.clone(),
+		stack:     s.stack,
+		mem:       s.mem.clone(),
+		storage:   s.storage,
+		transient: s.transient,
 	}
 }
 
@@ -61,9 +68,13 @@ func joinStates(s1 absState, s2 absState) (absState, bool) {
 	}
 	nStack, diffStack := joinStacks(s1.stack, s2.stack, MagicBool(true))
 	nMem, diffMem := joinMems(s1.mem, s2.mem)
+	nStorage, diffStorage := joinStorages(s1.storage, s2.storage)
+	nTransient, diffTransient := joinStorages(s1.transient, s2.transient)
 	ns := absState{
-		stack: nStack,
-		mem:   nMem,
+		stack:     nStack,
+		mem:       nMem,
+		storage:   nStorage,
+		transient: nTransient,
 	}
-	return ns, diffStack || diffMem
+	return ns, diffStack || diffMem || diffStorage || diffTransient
 }
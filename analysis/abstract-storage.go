@@ -0,0 +1,250 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// StorageSnapshot is the initial storage of the analyzed contract.
+// Slots that are not contained in the snapshot are zero.
+type StorageSnapshot struct {
+	slots   map[common.Hash]common.Hash
+	version common.Hash
+}
+
+// NewStorageSnapshot creates a snapshot from the given slots.
+func NewStorageSnapshot(slots map[common.Hash]common.Hash) *StorageSnapshot {
+	s := &StorageSnapshot{
+		slots: map[common.Hash]common.Hash{},
+	}
+	var keys []common.Hash
+	for k, v := range slots {
+		s.slots[k] = v
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	var data []byte
+	for _, k := range keys {
+		v := s.slots[k]
+		data = append(data, k[:]...)
+		data = append(data, v[:]...)
+	}
+	s.version = crypto.Keccak256Hash(data)
+	return s
+}
+
+// ReadStorageSnapshot reads the storage of the given address from a geth-style allocation in JSON format.
+// Both a plain allocation and a genesis file with an "alloc" field are accepted.
+func ReadStorageSnapshot(r io.Reader, addr common.Address) (*StorageSnapshot, error) {
+	type account struct {
+		Storage map[string]string `json:"storage"`
+	}
+	var raw map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid storage snapshot: %v", err)
+	}
+	if alloc, found := raw["alloc"]; found {
+		raw = nil
+		if err := json.Unmarshal(alloc, &raw); err != nil {
+			return nil, fmt.Errorf("invalid storage snapshot: %v", err)
+		}
+	}
+	for addrStr, accJSON := range raw {
+		if common.HexToAddress(addrStr) != addr {
+			continue
+		}
+		var acc account
+		if err := json.Unmarshal(accJSON, &acc); err != nil {
+			return nil, fmt.Errorf("invalid account in storage snapshot: %v", err)
+		}
+		slots := map[common.Hash]common.Hash{}
+		for k, v := range acc.Storage {
+			slots[common.HexToHash(k)] = common.HexToHash(v)
+		}
+		return NewStorageSnapshot(slots), nil
+	}
+	return nil, fmt.Errorf("address %x not found in storage snapshot", addr)
+}
+
+// Version returns a hash that identifies the contents of the snapshot.
+func (s *StorageSnapshot) Version() common.Hash {
+	return s.version
+}
+
+func (s *StorageSnapshot) get(key *big.Int) *big.Int {
+	v := s.slots[common.BigToHash(key)]
+	return new(big.Int).SetBytes(v[:])
+}
+
+// absStorage represents the contents of storage along a path.
+// It is immutable and operations that modify it return a new value.
+type absStorage struct {
+	// initial is the storage at the beginning of the path (nil if unknown).
+	initial *StorageSnapshot
+	// written contains the values (possibly top) of slots that were written along the path.
+	written map[common.Hash]*absVal
+	// clobbered is true if any slot may have been modified in an unknown way (e.g., by a call).
+	clobbered bool
+}
+
+// zeroStorage is a snapshot in which all slots are zero.
+var zeroStorage = NewStorageSnapshot(nil)
+
+func unknownStorage() absStorage {
+	return absStorage{}
+}
+
+func initialStorage(snapshot *StorageSnapshot) absStorage {
+	return absStorage{initial: snapshot}
+}
+
+// load returns the value of the given slot.
+func (s absStorage) load(key *absVal) *absVal {
+	if isTop(key) {
+		return topVal()
+	}
+	if v, found := s.written[common.BigToHash(key)]; found {
+		return new(big.Int).Set(v)
+	}
+	if s.clobbered || s.initial == nil {
+		return topVal()
+	}
+	return s.initial.get(key)
+}
+
+// store returns the storage after writing the given value to the given slot.
+func (s absStorage) store(key, val *absVal) absStorage {
+	if isTop(key) {
+		return s.clobber()
+	}
+	ns := absStorage{
+		initial:   s.initial,
+		written:   map[common.Hash]*absVal{},
+		clobbered: s.clobbered,
+	}
+	for k, v := range s.written {
+		ns.written[k] = v
+	}
+	ns.written[common.BigToHash(key)] = new(big.Int).Set(val)
+	return ns
+}
+
+// clobber returns the storage after an unknown modification.
+func (s absStorage) clobber() absStorage {
+	return absStorage{
+		initial:   s.initial,
+		clobbered: true,
+	}
+}
+
+// joinStorages computes the join of two storage elements.
+// It also returns a boolean indicating whether we went up (relative to the first storage) in the lattice.
+func joinStorages(s1 absStorage, s2 absStorage) (absStorage, bool) {
+	initial := s1.initial
+	diff := false
+	if s1.initial != s2.initial {
+		initial = nil
+		diff = s1.initial != nil
+	}
+	ns := absStorage{
+		initial:   initial,
+		written:   map[common.Hash]*absVal{},
+		clobbered: s1.clobbered || s2.clobbered,
+	}
+	diff = diff || ns.clobbered != s1.clobbered
+	for k, v1 := range s1.written {
+		v2, found := s2.written[k]
+		if !found {
+			// The slot may still hold its initial value on the second path.
+			v2 = topVal()
+		}
+		v, diffV := joinVals(v1, v2)
+		ns.written[k] = v
+		diff = diff || diffV
+	}
+	for k := range s2.written {
+		if _, found := s1.written[k]; !found {
+			ns.written[k] = topVal()
+			diff = true
+		}
+	}
+	return ns, diff
+}
+
+// opSload loads a value from the abstract storage.
+func opSload(env execEnv) (stepRes, error) {
+	env2 := env.withStackCopy().withPcCopy()
+	stack2, _ := env2.unpack()
+	key := stack2.Pop()
+	stack2.Push(env2.st.storage.load(key))
+	return nextPcRes(env2), nil
+}
+
+// opSstore stores a value in the abstract storage.
+func opSstore(env execEnv) (stepRes, error) {
+	env2 := env.withStackCopy().withPcCopy()
+	stack2, _ := env2.unpack()
+	key, val := stack2.Pop(), stack2.Pop()
+	env2.st.storage = env2.st.storage.store(key, val)
+	return nextPcRes(env2), nil
+}
+
+// opTload loads a value from the abstract transient storage.
+func opTload(env execEnv) (stepRes, error) {
+	env2 := env.withStackCopy().withPcCopy()
+	stack2, _ := env2.unpack()
+	key := stack2.Pop()
+	stack2.Push(env2.st.transient.load(key))
+	return nextPcRes(env2), nil
+}
+
+// opTstore stores a value in the abstract transient storage.
+func opTstore(env execEnv) (stepRes, error) {
+	env2 := env.withStackCopy().withPcCopy()
+	stack2, _ := env2.unpack()
+	key, val := stack2.Pop(), stack2.Pop()
+	env2.st.transient = env2.st.transient.store(key, val)
+	return nextPcRes(env2), nil
+}
+
+// clobberingStorage returns a function that behaves like the given one, but also clobbers storage and transient
+// storage. This is used for operations that can execute arbitrary code (e.g., by reentering the contract).
+func clobberingStorage(exec execFn) execFn {
+	return func(env execEnv) (stepRes, error) {
+		res, err := exec(env)
+		if err != nil {
+			return res, err
+		}
+		for i := range res.postStates {
+			res.postStates[i].st.storage = res.postStates[i].st.storage.clobber()
+			res.postStates[i].st.transient = res.postStates[i].st.transient.clobber()
+		}
+		return res, nil
+	}
+}
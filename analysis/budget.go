@@ -0,0 +1,96 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"context"
+	"time"
+)
+
+// BudgetPolicy determines how results are cached if the analysis exceeds its budget.
+type BudgetPolicy int
+
+const (
+	// RetryOnBudgetExceeded does not cache such results so that the analysis is retried (e.g., with more time).
+	RetryOnBudgetExceeded BudgetPolicy = iota
+	// CacheBudgetExceeded caches such results like any other result and asks the fuzzer not to retry.
+	CacheBudgetExceeded
+)
+
+// budget limits the work of a single analysis.
+// A nil budget is unlimited.
+type budget struct {
+	ctx           context.Context
+	deadline      time.Time
+	maxIterations int
+	maxStates     int
+	iterations    int
+}
+
+func newBudget(ctx context.Context, cfg Config) *budget {
+	b := &budget{
+		ctx:           ctx,
+		maxIterations: cfg.MaxIterations,
+		maxStates:     cfg.MaxStates,
+	}
+	if 0 < cfg.Timeout {
+		b.deadline = time.Now().Add(cfg.Timeout)
+	}
+	return b
+}
+
+// check returns true if the time limit was exceeded and an error if the context was cancelled.
+func (b *budget) check() (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+	if err := b.ctx.Err(); err != nil {
+		return false, err
+	}
+	return !b.deadline.IsZero() && time.Now().After(b.deadline), nil
+}
+
+// step records an iteration of the worklist algorithm, which currently keeps the given number of states.
+// It returns true if a limit was exceeded and an error if the context was cancelled.
+func (b *budget) step(numStates int) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+	b.iterations++
+	if 0 < b.maxIterations && b.maxIterations < b.iterations {
+		return true, nil
+	}
+	if 0 < b.maxStates && b.maxStates < numStates {
+		return true, nil
+	}
+	return b.check()
+}
+
+// budgetExceeded returns the result of an analysis that exceeded its budget.
+func (c Config) budgetExceeded() result {
+	return result{
+		mayFail:      true,
+		failureCause: BudgetExceededFail,
+		avoidRetry:   c.BudgetPolicy == CacheBudgetExceeded,
+	}
+}
+
+// isCacheable determines if the given result may be cached.
+func (c Config) isCacheable(res result) bool {
+	return res.failureCause != BudgetExceededFail || c.BudgetPolicy == CacheBudgetExceeded
+}
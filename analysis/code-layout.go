@@ -0,0 +1,251 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// Metadata is the decoded CBOR metadata that the Solidity compiler appends to the code.
+type Metadata struct {
+	// CompilerVersion is the version of the compiler (e.g., "0.8.19"). It is empty if the metadata does not
+	// contain the version (as for compilers before 0.5.9).
+	CompilerVersion string
+	// IPFSHash is the IPFS multihash of the metadata file (nil if absent).
+	IPFSHash []byte
+	// SwarmHash is the Swarm hash of the metadata file (nil if absent).
+	SwarmHash []byte
+	// Experimental is true if the code was compiled with experimental features.
+	Experimental bool
+	// Offset is the offset of the metadata trailer (including its two-byte length) in the code.
+	Offset uint64
+}
+
+// CodeLayout splits code into executable code, the metadata trailer and unreachable data.
+type CodeLayout struct {
+	// Metadata is the decoded metadata trailer (nil if there is none).
+	Metadata *Metadata
+	// isData marks the bytes that are never executed (i.e., unreachable bytes and the metadata trailer).
+	isData []bool
+}
+
+// AnalyzeCodeLayout determines the layout of the given code.
+// A byte is considered unreachable if it cannot be reached from PC 0 or from a JUMPDEST without executing an
+// instruction that ends execution or jumps (e.g., the bytes between a final INVALID and the metadata trailer).
+func AnalyzeCodeLayout(code []byte) *CodeLayout {
+	layout := &CodeLayout{
+		isData: make([]bool, len(code)),
+	}
+	md, hasMetadata := decodeMetadataTrailer(code)
+	if hasMetadata && !markUnreachable(code[:md.Offset], layout.isData) {
+		layout.Metadata = md
+		for i := md.Offset; i < uint64(len(code)); i++ {
+			layout.isData[i] = true
+		}
+		return layout
+	}
+	// Execution may fall through into the trailer, which means that it is probably not metadata after all.
+	markUnreachable(code, layout.isData)
+	return layout
+}
+
+// markUnreachable marks the unreachable bytes of the given code.
+// It returns true if execution may fall through beyond the end of the code.
+func markUnreachable(code []byte, isData []bool) bool {
+	reachable := true
+	for pc := uint64(0); pc < uint64(len(code)); {
+		op := vm.OpCode(code[pc])
+		if op == vm.JUMPDEST {
+			reachable = true
+		}
+		size := uint64(1)
+		if vm.PUSH1 <= op && op <= vm.PUSH32 {
+			size += uint64(op - vm.PUSH1 + 1)
+		}
+		for i := pc; i < pc+size && i < uint64(len(isData)); i++ {
+			isData[i] = !reachable
+		}
+		pc += size
+		switch op {
+		case vm.STOP, vm.JUMP, vm.RETURN, vm.REVERT, vm.SELFDESTRUCT, 0xfe:
+			reachable = false
+		}
+	}
+	return reachable
+}
+
+// IsData returns true if the byte at the given PC is never executed.
+func (l *CodeLayout) IsData(pc uint64) bool {
+	return pc < uint64(len(l.isData)) && l.isData[pc]
+}
+
+// CompilerVersion returns the compiler version from the metadata (or an empty string if it is unknown).
+func (l *CodeLayout) CompilerVersion() string {
+	if l.Metadata == nil {
+		return ""
+	}
+	return l.Metadata.CompilerVersion
+}
+
+// decodeMetadataTrailer decodes the CBOR metadata at the end of the code.
+// The trailer consists of a CBOR map followed by its length as a big-endian 16-bit integer.
+func decodeMetadataTrailer(code []byte) (*Metadata, bool) {
+	if len(code) < 2 {
+		return nil, false
+	}
+	length := uint64(binary.BigEndian.Uint16(code[len(code)-2:]))
+	if length == 0 || uint64(len(code)-2) < length {
+		return nil, false
+	}
+	start := uint64(len(code)-2) - length
+	dec := cborDecoder{data: code[start : len(code)-2]}
+	entries, err := dec.decodeMap()
+	if err != nil || dec.pos != len(dec.data) {
+		return nil, false
+	}
+
+	md := &Metadata{Offset: start}
+	for key, val := range entries {
+		switch key {
+		case "ipfs":
+			md.IPFSHash, _ = val.([]byte)
+		case "bzzr0", "bzzr1":
+			md.SwarmHash, _ = val.([]byte)
+		case "solc":
+			switch v := val.(type) {
+			case []byte:
+				if len(v) == 3 {
+					md.CompilerVersion = fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+				}
+			case string:
+				// Pre-release compilers store the full version string.
+				md.CompilerVersion = v
+			}
+		case "experimental":
+			md.Experimental, _ = val.(bool)
+		}
+	}
+	if md.IPFSHash == nil && md.SwarmHash == nil && md.CompilerVersion == "" {
+		// We require a hash or a compiler version to avoid misinterpreting code that happens to end like a map.
+		return nil, false
+	}
+	return md, true
+}
+
+// cborDecoder decodes the subset of CBOR that is used for compiler metadata (i.e., a map with text keys and byte
+// string, text string, integer or boolean values).
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) decodeHead() (byte, uint64, error) {
+	if len(d.data) <= d.pos {
+		return 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	b := d.data[d.pos]
+	d.pos++
+	major, info := b>>5, b&0x1f
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("unsupported CBOR item")
+	}
+	if len(d.data)-d.pos < size {
+		return 0, 0, fmt.Errorf("unexpected end of CBOR data")
+	}
+	var arg uint64
+	for _, ab := range d.data[d.pos : d.pos+size] {
+		arg = arg<<8 | uint64(ab)
+	}
+	d.pos += size
+	return major, arg, nil
+}
+
+func (d *cborDecoder) decodeBytes(n uint64) ([]byte, error) {
+	if uint64(len(d.data)-d.pos) < n {
+		return nil, fmt.Errorf("unexpected end of CBOR data")
+	}
+	bs := append([]byte{}, d.data[d.pos:d.pos+int(n)]...)
+	d.pos += int(n)
+	return bs, nil
+}
+
+func (d *cborDecoder) decodeValue() (interface{}, error) {
+	major, arg, err := d.decodeHead()
+	if err != nil {
+		return nil, err
+	}
+	switch major {
+	case 0:
+		return arg, nil
+	case 2:
+		return d.decodeBytes(arg)
+	case 3:
+		bs, err := d.decodeBytes(arg)
+		return string(bs), err
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported CBOR item")
+}
+
+func (d *cborDecoder) decodeMap() (map[string]interface{}, error) {
+	major, n, err := d.decodeHead()
+	if err != nil {
+		return nil, err
+	}
+	if major != 5 || n == 0 {
+		return nil, fmt.Errorf("expected non-empty CBOR map")
+	}
+	entries := map[string]interface{}{}
+	for i := uint64(0); i < n; i++ {
+		key, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		keyStr, ok := key.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected text key in CBOR map")
+		}
+		val, err := d.decodeValue()
+		if err != nil {
+			return nil, err
+		}
+		entries[keyStr] = val
+	}
+	return entries, nil
+}
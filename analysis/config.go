@@ -0,0 +1,178 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// Config contains the settings of a LookaheadAnalyzer.
+// Fields with zero values are set to their defaults (see DefaultConfig).
+type Config struct {
+	// Fork determines the available instructions (default: DefaultFork).
+	Fork Fork
+	// Extensions are opcodes that are added to the instructions of the fork.
+	Extensions []OpcodeExtension
+	// ContractAddress is the address of the analyzed contract (default: 0x0123456789abcdef).
+	ContractAddress common.Address
+	// MaxPrefixLen is the maximum length of a prefix that is analyzed (default: 8192).
+	MaxPrefixLen int
+	// MaxDisjuncts is the number of abstract states (with different stack sizes) that are kept separately for a PC
+	// before further states are joined (default: 0).
+	MaxDisjuncts int
+	// UseBoundedJoins stops exploring states that were joined with an existing state.
+	UseBoundedJoins bool
+	// FailOnTopMemResize reports a failure if memory is resized by an unknown amount (instead of making memory top).
+	FailOnTopMemResize bool
+	// BackpropSteps is the number of instructions that are inspected to refine the stack at a branch (default: 16).
+	BackpropSteps int
+	// EnvSourceSteps is the number of instructions that are inspected to find the source of an environment value
+	// (default: 8).
+	EnvSourceSteps int
+	// MaxRevertPayloadSize is the maximum size of revert payloads that are inspected for targets (default: 1024).
+	MaxRevertPayloadSize int
+	// MaxRuntimeCodeSize is the maximum size of runtime code that is extracted from creation code (default: 0x10000).
+	MaxRuntimeCodeSize int
+	// CacheBudget is the (approximate) number of bytes that cached results may use before the least recently used
+	// ones are evicted (default: 64 MiB). Results of prefixes and results of suffixes have separate budgets.
+	CacheBudget int
+	// MaxIterations is the number of worklist iterations after which the analysis of a suffix gives up (default: 0,
+	// i.e., unlimited).
+	MaxIterations int
+	// MaxStates is the number of abstract states after which the analysis of a suffix gives up (default: 0, i.e.,
+	// unlimited).
+	MaxStates int
+	// Timeout is the time after which the analysis of a prefix and its suffix gives up (default: 0, i.e., unlimited).
+	Timeout time.Duration
+	// BudgetPolicy determines if results of analyses that give up are cached (default: RetryOnBudgetExceeded).
+	BudgetPolicy BudgetPolicy
+	// UseDummyAnalysis disables the analysis (i.e., no suffix is ever ignored).
+	UseDummyAnalysis bool
+	// Verbose prints the analyzed prefixes and code.
+	Verbose bool
+}
+
+// DefaultConfig returns the default configuration.
+func DefaultConfig() Config {
+	return Config{
+		Fork:                 DefaultFork,
+		ContractAddress:      common.HexToAddress("0x0123456789abcdef"),
+		MaxPrefixLen:         8192,
+		MaxDisjuncts:         0,
+		BackpropSteps:        16,
+		EnvSourceSteps:       8,
+		MaxRevertPayloadSize: 1024,
+		MaxRuntimeCodeSize:   0x10000,
+		CacheBudget:          64 << 20,
+	}
+}
+
+// withDefaults returns a copy of the configuration in which zero values are replaced by their defaults.
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Fork == 0 {
+		c.Fork = d.Fork
+	}
+	if c.ContractAddress == (common.Address{}) {
+		c.ContractAddress = d.ContractAddress
+	}
+	if c.MaxPrefixLen == 0 {
+		c.MaxPrefixLen = d.MaxPrefixLen
+	}
+	if c.BackpropSteps == 0 {
+		c.BackpropSteps = d.BackpropSteps
+	}
+	if c.EnvSourceSteps == 0 {
+		c.EnvSourceSteps = d.EnvSourceSteps
+	}
+	if c.MaxRevertPayloadSize == 0 {
+		c.MaxRevertPayloadSize = d.MaxRevertPayloadSize
+	}
+	if c.MaxRuntimeCodeSize == 0 {
+		c.MaxRuntimeCodeSize = d.MaxRuntimeCodeSize
+	}
+	if c.CacheBudget == 0 {
+		c.CacheBudget = d.CacheBudget
+	}
+	c.Extensions = append([]OpcodeExtension{}, c.Extensions...)
+	return c
+}
+
+// Validate checks that the configuration (after setting defaults) is well-formed.
+func (c Config) Validate() error {
+	c = c.withDefaults()
+	if _, found := forkNames[c.Fork]; !found {
+		return fmt.Errorf("unknown fork %v", c.Fork)
+	}
+	for name, val := range map[string]int{
+		"MaxPrefixLen":         c.MaxPrefixLen,
+		"MaxDisjuncts":         c.MaxDisjuncts,
+		"BackpropSteps":        c.BackpropSteps,
+		"EnvSourceSteps":       c.EnvSourceSteps,
+		"MaxRevertPayloadSize": c.MaxRevertPayloadSize,
+		"MaxRuntimeCodeSize":   c.MaxRuntimeCodeSize,
+		"CacheBudget":          c.CacheBudget,
+		"MaxIterations":        c.MaxIterations,
+		"MaxStates":            c.MaxStates,
+	} {
+		if val < 0 {
+			return fmt.Errorf("expected non-negative %v, but got %v", name, val)
+		}
+	}
+	if c.Timeout < 0 {
+		return fmt.Errorf("expected non-negative Timeout, but got %v", c.Timeout)
+	}
+	if c.BudgetPolicy != RetryOnBudgetExceeded && c.BudgetPolicy != CacheBudgetExceeded {
+		return fmt.Errorf("unknown budget policy %v", c.BudgetPolicy)
+	}
+	defined := map[vm.OpCode]bool{}
+	for _, ext := range c.Extensions {
+		if defined[ext.Opcode] {
+			return fmt.Errorf("opcode %#x is extended more than once", byte(ext.Opcode))
+		}
+		defined[ext.Opcode] = true
+		if err := ext.validate(c.Fork); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hash returns a digest of all settings that may affect analysis results (i.e., all settings except for the cache
+// budget and verbosity). The budget policy is included since it determines if results that exceeded the budget are
+// cached.
+// Extensions are identified by their versions and all settings that can be compared (see OpcodeExtension).
+func (c Config) hash() [sha256.Size]byte {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v:%x:%v:%v:%v:%v:%v:%v:%v:%v:%v:%v:%v:%v:%v\n", c.Fork, c.ContractAddress, c.MaxPrefixLen,
+		c.MaxDisjuncts, c.UseBoundedJoins, c.FailOnTopMemResize, c.BackpropSteps, c.EnvSourceSteps,
+		c.MaxRevertPayloadSize, c.MaxRuntimeCodeSize, c.UseDummyAnalysis, c.MaxIterations, c.MaxStates, c.Timeout,
+		c.BudgetPolicy)
+	for _, ext := range c.Extensions {
+		ext.writeDigest(h)
+	}
+	var digest [sha256.Size]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
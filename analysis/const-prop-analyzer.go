@@ -36,6 +36,11 @@ type result struct {
 	mayFail      bool
 	failureCause string
 	avoidRetry   bool
+	// failurePC is the PC at which the possible failure was found (if hasFailurePC is true).
+	failurePC    pcType
+	hasFailurePC bool
+	// inPrefix is true if the possible failure was found in the prefix.
+	inPrefix bool
 }
 
 func noFail() result {
@@ -49,11 +54,21 @@ func mayFail(cause string) result {
 	}
 }
 
+func mayFailAt(cause string, pc pcType) result {
+	return result{
+		mayFail:      true,
+		failureCause: cause,
+		failurePC:    pc,
+		hasFailurePC: true,
+	}
+}
+
 func prefixMayFail(cause string) result {
 	return result{
 		mayFail:      true,
 		failureCause: cause,
 		avoidRetry:   true,
+		inPrefix:     true,
 	}
 }
 
@@ -95,21 +110,75 @@ type constPropAnalyzer struct {
 	failOnTopMemResize bool
 	useBoundedJoins    bool
 	verbose            bool
+	// initialStorage is the storage at the beginning of the prefix (nil if unknown).
+	initialStorage *StorageSnapshot
+	// initialTransient is the transient storage at the beginning of the prefix.
+	initialTransient absStorage
+	// hasUnknownArgs is true for creation code that is followed by unknown constructor arguments.
+	hasUnknownArgs bool
+	// layout determines which bytes of the code are never executed.
+	layout *CodeLayout
+	// checkpoints contains checkpoints of the prefix analysis and checkpointRoot is the node for the empty prefix
+	// (nil if no checkpoints are used).
+	checkpoints    *prefixTrie
+	checkpointRoot *prefixTrieNode
+	// budget limits the work of the analysis (nil if unlimited).
+	budget *budget
+	// numStates is the number of states that the last analysis of a suffix explored and suffixCacheHit is true if
+	// its result was cached instead.
+	numStates      int
+	suffixCacheHit bool
+	// witness is the path to the possible failure that the last analysis of a suffix found (if any).
+	witness *Witness
+	// exhaustive is true if the analysis of the suffix should find all possible failures, which are recorded in
+	// failureSites.
+	exhaustive   bool
+	failureSites []FailureSite
+	// collectMagic is true if an exhaustive analysis should also find the constants that are compared with unknown
+	// values on paths to possible failures, which are recorded in magicValues.
+	collectMagic bool
+	magicValues  []MagicValue
+	// taint tracks the inputs that values depend on (nil if it is not tracked).
+	taint *taintAnalysis
 }
 
-func newConstPropAnalyzer(contract *vm.Contract, codeHash common.Hash, interpreter *vm.EVMInterpreter, analyzer *LookaheadAnalyzer) *constPropAnalyzer {
+// newConstPropAnalyzer creates an analyzer for the given contract, whose code has the given layout.
+func newConstPropAnalyzer(contract *vm.Contract, codeHash common.Hash, layout *CodeLayout, interpreter *vm.EVMInterpreter, analyzer *LookaheadAnalyzer) *constPropAnalyzer {
 	return &constPropAnalyzer{
 		contract:           contract,
 		codeHash:           codeHash,
 		interpreter:        interpreter,
 		analyzer:           analyzer,
-		failOnTopMemResize: MagicBool(false),
-		verbose:            MagicBool(false),
-		useBoundedJoins:    MagicBool(false),
-		maxDisjuncts:       MagicInt(0),
+		failOnTopMemResize: analyzer.config.FailOnTopMemResize,
+		verbose:            analyzer.config.Verbose,
+		useBoundedJoins:    analyzer.config.UseBoundedJoins,
+		maxDisjuncts:       analyzer.config.MaxDisjuncts,
+		layout:             layout,
+		// Transient storage is zero at the beginning of every transaction.
+		initialTransient: initialStorage(zeroStorage),
 	}
 }
 
+// newAbsJumpTable creates the abstract jump table for the analyzed code.
+func (a *constPropAnalyzer) newAbsJumpTable(forPrefix bool) absJumpTable {
+	jt := newAbsJumpTable(forPrefix, a.analyzer.environment, a.analyzer.config)
+	for _, ext := range a.analyzer.config.Extensions {
+		jt[ext.Opcode] = ext.Abstract.op
+	}
+	concJt := a.interpreter.Cfg.JumpTable
+	for op := range jt {
+		if !concJt[op].Valid {
+			// The instruction is not available in the configured fork.
+			jt[op] = absOp{}
+		}
+	}
+	if a.hasUnknownArgs {
+		jt[vm.CODESIZE] = makeCreationCodeSizeOp()
+		jt[vm.CODECOPY] = makeCreationCodeCopyOp(uint64(len(a.contract.Code)))
+	}
+	return jt
+}
+
 func (a *constPropAnalyzer) Analyze(execPrefix execPrefix) (result, error, error) {
 	if a.verbose {
 		var pre []uint64
@@ -127,104 +196,128 @@ func (a *constPropAnalyzer) Analyze(execPrefix execPrefix) (result, error, error
 	}
 
 	concJt := a.interpreter.Cfg.JumpTable
-	absJtPrefix := newAbsJumpTable(true)
+	absJtPrefix := a.newAbsJumpTable(true)
 	prefixRes, preErr := a.calculatePrecondition(concJt, absJtPrefix, execPrefix)
 	if preErr != nil {
 		return prefixMayFail(PrefixComputationFail), preErr, nil
 	}
-	if prefixRes.mayFail {
-		return prefixMayFail(fmt.Sprintf("%v(%v)", PrefixComputationFail, prefixRes.failureCause)), nil, nil
+	if prefixRes.mayFail && prefixRes.failureCause == BudgetExceededFail {
+		res := a.analyzer.config.budgetExceeded()
+		res.inPrefix = true
+		return res, nil, nil
 	}
-
-	absJt := newAbsJumpTable(false)
-	states := map[string]absState{}
-	keys := map[pcType]map[string]bool{}
-	ppcMap := newPrevPCMap()
-	var worklist []string
-	workset := map[string]pcType{}
-
-	addNewStates := func(prevPC pcType, newStates []pcAndSt) {
-		for _, st := range newStates {
-			pc := st.pc
-			ppcMap.addPrevPC(pc, prevPC)
-
-			newState := st.st.withStackCopy().withMemCopy()
-
-			stSize := -1
-			if !newState.isBot && !newState.stack.isTop && newState.stack.stack != nil {
-				stSize = newState.stack.len()
-			}
-			loc := fmt.Sprintf("%x:%x", pc, stSize)
-
-			oldState, exists := states[loc]
-			ks := keys[pc]
-			if ks == nil {
-				ks = map[string]bool{}
-			}
-			numDisjs := len(ks)
-			if !exists && a.maxDisjuncts <= numDisjs {
-				loc = fmt.Sprintf("%x:%x", pc, -1)
-				oldState, exists = states[loc]
-			}
-			if exists {
-				var diff bool
-				newState, diff = joinStates(oldState, newState)
-				if !diff || a.useBoundedJoins {
-					continue
-				}
-			}
-
-			states[loc] = newState
-			ks[loc] = true
-			keys[pc] = ks
-			if _, ex := workset[loc]; !ex {
-				worklist = append(worklist, loc)
-				workset[loc] = pc
-			}
+	if prefixRes.mayFail {
+		res := prefixMayFail(fmt.Sprintf("%v(%v)", PrefixComputationFail, prefixRes.failureCause))
+		// Steps in the prefix that may fail have no successors, so only the last one can fail.
+		if 0 < len(execPrefix) {
+			res.failurePC, res.hasFailurePC = execPrefix[len(execPrefix)-1], true
 		}
+		return res, nil, nil
 	}
 
-	popState := func() (absState, pcType) {
-		ret := worklist[0]
-		worklist = worklist[1:]
-		pc := workset[ret]
-		delete(workset, ret)
-		return states[ret], pc
+	absJt := a.newAbsJumpTable(false)
+	fp := a.newFixpoint(concJt, absJt)
+	fp.exhaustive = a.exhaustive
+	if a.collectMagic {
+		fp.comparisons = map[string][]MagicValue{}
 	}
-
 	prefixLen := len(execPrefix)
-	if 0 < prefixLen {
-		lastPrefixPC := execPrefix[prefixLen-1]
-		addNewStates(lastPrefixPC, prefixRes.postStates)
+	if prefixLen == 0 {
+		res, err := fp.run()
+		a.recordSuffixAnalysis(fp)
+		return res, nil, err
+	}
+	lastPrefixPC := execPrefix[prefixLen-1]
+	if a.exhaustive {
+		// Only the first possible failure of a suffix is cached.
+		fp.addNewStates(&lastPrefixPC, prefixRes.postStates)
+		res, err := fp.run()
+		a.recordSuffixAnalysis(fp)
+		return res, nil, err
+	}
+	key := a.suffixKey(lastPrefixPC, prefixRes.postStates)
+	if res, found := a.analyzer.suffixCache.get(key); found {
+		a.suffixCacheHit = true
+		return res, nil, nil
+	}
+	fp.addNewStates(&lastPrefixPC, prefixRes.postStates)
+	res, err := fp.run()
+	a.recordSuffixAnalysis(fp)
+	if err == nil && a.analyzer.config.isCacheable(res) {
+		a.analyzer.suffixCache.put(key, res)
 	}
+	return res, nil, err
+}
 
-	for 0 < len(worklist) {
-		st, pc := popState()
-		if st.isBot {
-			continue
-		}
-		opcode := a.contract.GetOp(uint64(pc))
-		res, stepErr := a.step(pc, ppcMap, st, concJt[opcode], opcode, absJt, false)
-		if stepErr != nil {
-			return mayFail(StepExecFail), nil, stepErr
-		}
-		if res.mayFail {
-			return mayFail(res.failureCause), nil, nil
-		}
-		addNewStates(pc, res.postStates)
+// recordSuffixAnalysis records the number of explored states and the witness of a possible failure.
+func (a *constPropAnalyzer) recordSuffixAnalysis(fp *fixpoint) {
+	a.numStates = len(fp.states)
+	if fp.failureLoc != "" {
+		a.witness = fp.witness(fp.failureLoc)
 	}
+	if fp.exhaustive {
+		a.failureSites = fp.failureSites()
+	}
+	if fp.comparisons != nil {
+		a.magicValues = fp.magicValues()
+	}
+}
 
-	return noFail(), nil, nil
+// stateBeforeLastInstruction computes the abstract state before the last instruction of the prefix (e.g., before a
+// call that has not returned yet).
+func (a *constPropAnalyzer) stateBeforeLastInstruction(prefix execPrefix) (absState, error) {
+	prefixLen := len(prefix)
+	if prefixLen == 0 {
+		return botState(), fmt.Errorf("expected non-empty prefix")
+	}
+	shorterPrefix := execPrefix{}
+	for idx := 0; idx < prefixLen-1; idx++ {
+		shorterPrefix[idx] = prefix[idx]
+	}
+	res, err := a.calculatePrecondition(a.interpreter.Cfg.JumpTable, a.newAbsJumpTable(true), shorterPrefix)
+	if err != nil {
+		return botState(), err
+	}
+	st := botState()
+	for _, pcSt := range res.postStates {
+		if pcSt.pc == prefix[prefixLen-1] {
+			st, _ = joinStates(st, pcSt.st)
+		}
+	}
+	if st.isBot {
+		return botState(), fmt.Errorf("expected feasible prefix")
+	}
+	return st, nil
 }
 
 func (a *constPropAnalyzer) calculatePrecondition(concJt concJumpTable, absJt absJumpTable, execPrefix execPrefix) (stepRes, error) {
 	ppcMap := newPrevPCMap()
-	currRes := initRes()
-	for idx := 0; true; idx++ {
+	currRes := initRes(a.initialStorage, a.initialTransient)
+	start := 0
+	node := a.checkpointRoot
+	if node != nil {
+		// We resume from the last checkpoint along the prefix.
+		var cp *prefixCheckpoint
+		cp, start, node = node.lookup(execPrefix)
+		if cp != nil {
+			currRes, ppcMap = cp.resume()
+		}
+	}
+	for idx := start; true; idx++ {
 		pc, exists := execPrefix[idx]
 		if !exists {
+			if node != nil && start < idx && node.checkpoint == nil {
+				node.checkpoint = newPrefixCheckpoint(currRes, ppcMap)
+			}
 			break
 		}
+		exceeded, err := a.budget.check()
+		if err != nil {
+			return emptyRes(), err
+		}
+		if exceeded {
+			return failRes(BudgetExceededFail), nil
+		}
 
 		// Select from the results only the state that matchesBackwards the next pc in the prefix.
 		currSt := botState()
@@ -241,63 +334,84 @@ func (a *constPropAnalyzer) calculatePrecondition(concJt concJumpTable, absJt ab
 			return emptyRes(), fmt.Errorf("expected feasible prefix")
 		}
 		opcode := a.contract.GetOp(uint64(pc))
-		var err error
+		if a.taint != nil {
+			a.taint.stepPrefix(opcode, concJt[opcode], currSt)
+		}
 		currRes, err = a.step(pc, ppcMap, currSt, concJt[opcode], opcode, absJt, true)
 		if err != nil {
 			currRes = emptyRes()
 		}
+		if node != nil {
+			node = a.checkpoints.child(node, pc)
+			if node != nil && (idx+1)%checkpointInterval == 0 && node.checkpoint == nil {
+				node.checkpoint = newPrefixCheckpoint(currRes, ppcMap)
+			}
+		}
 	}
 	return currRes, nil
 }
 
-func (a *constPropAnalyzer) step(pc pcType, ppcMap *prevPCMap, st absState, conc vm.Operation, op vm.OpCode, jt absJumpTable, ignoreTargets bool) (stepRes, error) {
-	abstractOp := jt[op]
-	if abstractOp.valid != conc.Valid {
-		return failRes(InternalFail), nil
-	}
-
-	if a.analyzer.IsTargetingAssertionFailed() {
-		if op == vm.LOG1 {
-			// We look for the following event type:
-			// event AssertionFailed(string message);
-			isAssertionFailed := true
-			if st.isBot {
-				isAssertionFailed = false
-			} else if !st.stack.isTop && 3 <= st.stack.len() {
-				topic := st.stack.stack.Back(2)
-				magicTopic, _ := math.ParseBig256("0xb42604cb105a16c8f6db8a41e6b00c0c1b4826465e8bc504b3eb3e88b3e6a4a0")
-				if !isTop(topic) && topic.Cmp(magicTopic) != 0 {
-					isAssertionFailed = false
-				}
+// reachedTarget determines if the state reaches a target at the given instruction and returns the failure cause if
+// so. Reverts are checked for panic and revert targets first, then registered targets and finally either target
+// events, target instructions or (if there are no other targets) invalid instructions other than covered assertions.
+// The given validity is the one of the abstract operation.
+func (a *constPropAnalyzer) reachedTarget(pc pcType, op vm.OpCode, st absState, valid bool) (string, bool) {
+	if op == vm.REVERT && !st.isBot {
+		if a.analyzer.isTargetingPanics {
+			// Since Solidity 0.8, failing assertions (and other checks) revert with a Panic(uint256) payload.
+			if cause, mayPanic := a.mayRevertWithPanic(st); mayPanic {
+				return cause, true
 			}
-			if !ignoreTargets && isAssertionFailed {
-				return failRes(ReachedAssertionFailed), nil
+		}
+		if a.analyzer.hasRevertTargets() {
+			if cause, mayRevert := a.mayRevertWithTargetError(st); mayRevert {
+				return cause, true
 			}
 		}
-		if !abstractOp.valid {
-			return emptyRes(), nil
+	}
+
+	if a.analyzer.hasTargets() {
+		if cause, mayReach := a.mayReachTarget(pc, op, st); mayReach {
+			return cause, true
 		}
-	} else if a.analyzer.HasTargetInstructions() {
-		if !ignoreTargets && a.analyzer.IsTargetInstruction(a.codeHash, uint64(pc)) {
-			return failRes(ReachedTargetInstructionFail), nil
+	}
+
+	if a.analyzer.hasTargetEvents() {
+		if vm.LOG0 <= op && op <= vm.LOG4 {
+			if cause, mayEmit := a.mayEmitTargetEvent(op, st); mayEmit {
+				return cause, true
+			}
 		}
-		if !abstractOp.valid {
-			return emptyRes(), nil
+	} else if a.analyzer.hasTargetInstructions() || a.analyzer.hasTargets() {
+		if a.analyzer.isTargetInstructionAt(a.codeHash, uint64(pc)) {
+			return ReachedTargetInstructionFail, true
 		}
-	} else {
-		if !abstractOp.valid {
-			switch op {
-			case 0xfe:
-				if a.analyzer.IsCoveredAssertion(a.codeHash, uint64(pc)) {
-					// No need to report a failure since the assertion has already been covered.
-					return emptyRes(), nil
-				}
-			}
-			if !ignoreTargets {
-				return failRes(InvalidOpcodeFail), nil
-			}
-			return emptyRes(), nil
+	} else if !valid {
+		if op == 0xfe && a.analyzer.isCoveredAssertionAt(a.codeHash, uint64(pc)) {
+			// No need to report a failure since the assertion has already been covered.
+			return "", false
 		}
+		return InvalidOpcodeFail, true
+	}
+	return "", false
+}
+
+func (a *constPropAnalyzer) step(pc pcType, ppcMap *prevPCMap, st absState, conc vm.Operation, op vm.OpCode, jt absJumpTable, ignoreTargets bool) (stepRes, error) {
+	if !ignoreTargets && a.layout.IsData(uint64(pc)) {
+		// Data (e.g., the metadata trailer) is never executed, even if it contains a JUMPDEST or an INVALID.
+		return emptyRes(), nil
+	}
+
+	abstractOp := jt[op]
+	if abstractOp.valid != conc.Valid {
+		return failRes(InternalFail), nil
+	}
+
+	if cause, reached := a.reachedTarget(pc, op, st, abstractOp.valid); reached && !ignoreTargets {
+		return failRes(cause), nil
+	}
+	if !abstractOp.valid {
+		return emptyRes(), nil
 	}
 
 	if st.stack.isTop {
@@ -341,8 +455,10 @@ func (a *constPropAnalyzer) step(pc pcType, ppcMap *prevPCMap, st absState, conc
 	}
 
 	postSt := absState{
-		stack: st.stack,
-		mem:   postMem,
+		stack:     st.stack,
+		mem:       postMem,
+		storage:   st.storage,
+		transient: st.transient,
 	}
 	env := execEnv{
 		pc:          &pc,
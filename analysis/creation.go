@@ -0,0 +1,187 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// CreationResult is the result of analyzing creation (init) code.
+type CreationResult struct {
+	// MayFail is true if the constructor may reach a failure (e.g., a failing assertion or a target).
+	MayFail bool
+	// FailureCause is the cause of the first possible failure that was found.
+	FailureCause string
+	// RuntimeCode is the code that the constructor deploys, with known immutables substituted.
+	// It is nil if the runtime code could not be determined.
+	RuntimeCode []byte
+	// UnknownRuntimeBytes contains the offsets of bytes in the runtime code whose values are unknown
+	// (e.g., immutables that depend on constructor arguments). These bytes are zero in the runtime code.
+	UnknownRuntimeBytes []int
+}
+
+// codeCopyEvent records the (constant) arguments of a CODECOPY instruction.
+type codeCopyEvent struct {
+	memOffset  uint64
+	codeOffset uint64
+	size       uint64
+}
+
+// returnEvent records the (constant) arguments and the payload of a RETURN instruction.
+type returnEvent struct {
+	offset  uint64
+	size    uint64
+	payload []absByte
+}
+
+// makeCreationCodeSizeOp returns the CODESIZE operation for creation code whose constructor arguments are unknown.
+func makeCreationCodeSizeOp() absOp {
+	return makePopPushTopOp(0, 1)
+}
+
+// makeCreationCodeCopyOp returns the CODECOPY operation for creation code whose constructor arguments are unknown.
+// Any bytes that are copied from beyond the end of the code (i.e., from the arguments) are top.
+func makeCreationCodeCopyOp(codeLen uint64) absOp {
+	return absOp{
+		valid:   true,
+		memSize: makeMemFn(0, 2),
+		exec: func(env execEnv) (stepRes, error) {
+			stack, _ := env.unpack()
+			memOffset, codeOffset, size := stack.Back(0), stack.Back(1), stack.Back(2)
+			res, err := opCodeCopy(env)
+			if err != nil || isTop(memOffset) || isTop(codeOffset) || isTop(size) {
+				return res, err
+			}
+			off, cOff, sz := memOffset.Uint64(), codeOffset.Uint64(), size.Uint64()
+			if !codeOffset.IsUint64() || codeLen <= cOff {
+				for _, st := range res.postStates {
+					st.st.mem.set(off, sz, topBytes())
+				}
+			} else if codeLen-cOff < sz {
+				known := codeLen - cOff
+				for _, st := range res.postStates {
+					st.st.mem.set(off+known, sz-known, topBytes())
+				}
+			}
+			return res, nil
+		},
+	}
+}
+
+// analyzeCreation analyzes the constructor (starting at PC 0) and determines the deployed runtime code.
+func (a *constPropAnalyzer) analyzeCreation() (CreationResult, error) {
+	concJt := a.interpreter.Cfg.JumpTable
+	absJt := a.newAbsJumpTable(false)
+
+	var copies []codeCopyEvent
+	codeCopyExec := absJt[vm.CODECOPY].exec
+	absJt[vm.CODECOPY].exec = func(env execEnv) (stepRes, error) {
+		stack, _ := env.unpack()
+		memOffset, codeOffset, size := stack.Back(0), stack.Back(1), stack.Back(2)
+		if !isTop(memOffset) && !isTop(codeOffset) && !isTop(size) && codeOffset.IsUint64() {
+			copies = append(copies, codeCopyEvent{
+				memOffset:  memOffset.Uint64(),
+				codeOffset: codeOffset.Uint64(),
+				size:       size.Uint64(),
+			})
+		}
+		return codeCopyExec(env)
+	}
+
+	var returns []returnEvent
+	unknownReturn := false
+	absJt[vm.RETURN] = fromExec(func(env execEnv) (stepRes, error) {
+		payload := returnPayload(env.st, uint64(a.analyzer.config.MaxRuntimeCodeSize))
+		if payload == nil {
+			unknownReturn = true
+			return emptyRes(), nil
+		}
+		stack, _ := env.unpack()
+		returns = append(returns, returnEvent{
+			offset:  stack.Back(0).Uint64(),
+			size:    stack.Back(1).Uint64(),
+			payload: payload,
+		})
+		return emptyRes(), nil
+	})
+
+	fp := a.newFixpoint(concJt, absJt)
+	fp.exhaustive = true
+	fp.addNewStates(nil, initRes(a.initialStorage, a.initialTransient).postStates)
+	res, err := fp.run()
+	if err != nil {
+		return CreationResult{}, err
+	}
+	cr := CreationResult{
+		MayFail:      res.mayFail,
+		FailureCause: res.failureCause,
+	}
+	if unknownReturn || len(returns) == 0 {
+		return cr, nil
+	}
+	cr.RuntimeCode, cr.UnknownRuntimeBytes = a.runtimeCode(returns, copies)
+	return cr, nil
+}
+
+// runtimeCode joins the payloads of all returns and uses the code copied to the returned memory to resolve bytes that
+// only seem unknown.
+func (a *constPropAnalyzer) runtimeCode(returns []returnEvent, copies []codeCopyEvent) ([]byte, []int) {
+	payload := returns[0].payload
+	for _, ret := range returns[1:] {
+		if len(ret.payload) != len(payload) {
+			return nil, nil
+		}
+		joined := make([]absByte, len(payload))
+		for i := range payload {
+			joined[i], _ = joinBytes(payload[i], ret.payload[i])
+		}
+		payload = joined
+	}
+
+	// We look for the CODECOPY that copied the runtime code to the returned memory.
+	var template []byte
+	ret := returns[0]
+	for _, r := range returns[1:] {
+		if r.offset != ret.offset {
+			copies = nil
+		}
+	}
+	for _, cp := range copies {
+		if cp.memOffset == ret.offset && cp.size == ret.size {
+			template = make([]byte, cp.size)
+			if cp.codeOffset < uint64(len(a.contract.Code)) {
+				copy(template, a.contract.Code[cp.codeOffset:])
+			}
+			break
+		}
+	}
+
+	code := make([]byte, len(payload))
+	var unknown []int
+	for i, b := range payload {
+		if !b.isTop() {
+			code[i] = byte(b)
+		} else if template != nil && absByte(template[i]).isTop() {
+			// The top byte also occurs in the copied code and we assume that it was not overwritten.
+			code[i] = template[i]
+		} else {
+			unknown = append(unknown, i)
+		}
+	}
+	return code, unknown
+}
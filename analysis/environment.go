@@ -0,0 +1,295 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common/math"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// EnvValue describes what the analysis may assume about a block, transaction or account value.
+// The zero value is an unknown value.
+type EnvValue struct {
+	min *big.Int
+	max *big.Int
+}
+
+// UnknownValue returns a value about which nothing is assumed.
+func UnknownValue() EnvValue {
+	return EnvValue{}
+}
+
+// ConstantValue returns a value that is always equal to v.
+func ConstantValue(v *big.Int) EnvValue {
+	return EnvValue{
+		min: new(big.Int).Set(v),
+		max: new(big.Int).Set(v),
+	}
+}
+
+// RangeValue returns a value that always lies in [min, max].
+func RangeValue(min, max *big.Int) EnvValue {
+	return EnvValue{
+		min: new(big.Int).Set(min),
+		max: new(big.Int).Set(max),
+	}
+}
+
+// IsUnknown returns true if nothing is assumed about the value.
+func (v EnvValue) IsUnknown() bool {
+	return v.min == nil || v.max == nil
+}
+
+// constant returns the value if it is known to be a constant.
+func (v EnvValue) constant() (*big.Int, bool) {
+	if v.IsUnknown() || v.min.Cmp(v.max) != 0 {
+		return nil, false
+	}
+	return new(big.Int).Set(v.min), true
+}
+
+func (v EnvValue) validate() error {
+	if v.IsUnknown() {
+		return nil
+	}
+	if v.min.Sign() < 0 || math.MaxBig256.Cmp(v.max) < 0 {
+		return fmt.Errorf("expected values within [0, 2^256)")
+	}
+	if v.max.Cmp(v.min) < 0 {
+		return fmt.Errorf("expected non-empty range")
+	}
+	return nil
+}
+
+func (v EnvValue) String() string {
+	if v.IsUnknown() {
+		return "unknown"
+	}
+	if c, ok := v.constant(); ok {
+		return fmt.Sprintf("%#x", c)
+	}
+	return fmt.Sprintf("[%#x, %#x]", v.min, v.max)
+}
+
+// Environment describes the assumptions the analysis makes about the execution environment.
+// The zero value does not assume anything.
+type Environment struct {
+	Address     EnvValue
+	Caller      EnvValue
+	Origin      EnvValue
+	CallValue   EnvValue
+	Timestamp   EnvValue
+	Number      EnvValue
+	ChainID     EnvValue
+	GasPrice    EnvValue
+	SelfBalance EnvValue
+}
+
+// Validate checks that all declared values are well-formed.
+func (e Environment) Validate() error {
+	for op, v := range e.values() {
+		if err := v.validate(); err != nil {
+			return fmt.Errorf("invalid value for %v: %v", op, err)
+		}
+	}
+	return nil
+}
+
+// values maps the opcodes that read environment values to the corresponding declarations.
+func (e Environment) values() map[vm.OpCode]EnvValue {
+	return map[vm.OpCode]EnvValue{
+		vm.ADDRESS:     e.Address,
+		vm.CALLER:      e.Caller,
+		vm.ORIGIN:      e.Origin,
+		vm.CALLVALUE:   e.CallValue,
+		vm.TIMESTAMP:   e.Timestamp,
+		vm.NUMBER:      e.Number,
+		vm.CHAINID:     e.ChainID,
+		vm.GASPRICE:    e.GasPrice,
+		vm.SELFBALANCE: e.SelfBalance,
+	}
+}
+
+// makeEnvOp returns an operation that pushes the given environment value (or top if it is not a constant).
+func makeEnvOp(val EnvValue) absOp {
+	return fromExec(func(env execEnv) (stepRes, error) {
+		env2 := env.withStackCopy().withPcCopy()
+		stack2, _ := env2.unpack()
+		if c, ok := val.constant(); ok {
+			stack2.Push(c)
+		} else {
+			stack2.Push(topVal())
+		}
+		return nextPcRes(env2), nil
+	})
+}
+
+// makeCmpOp returns a comparison operation that uses declared ranges of environment values to decide comparisons
+// with top operands. The source of a top operand is searched among the given number of earlier instructions.
+func makeCmpOp(op vm.OpCode, environment Environment, maxSourceSteps int) absOp {
+	numArgs := 2
+	if op == vm.ISZERO {
+		numArgs = 1
+	}
+	stackOp := makeStackOp(uint(numArgs), 1)
+	values := environment.values()
+	return fromExec(func(env execEnv) (stepRes, error) {
+		stack, _ := env.unpack()
+		var args []*big.Int
+		var rngs []EnvValue
+		for i := 0; i < numArgs; i++ {
+			arg := stack.Back(i)
+			rng := UnknownValue()
+			if isTop(arg) {
+				if srcOp, found := envSourceBackwards(env.contract, env.ppcMap, *env.pc, i, maxSourceSteps); found {
+					rng = values[srcOp]
+				}
+			} else {
+				rng = ConstantValue(arg)
+			}
+			args = append(args, arg)
+			rngs = append(rngs, rng)
+		}
+		res, decided := compareRanges(op, rngs)
+		if !decided {
+			return stackOp.exec(env)
+		}
+		env2 := env.withStackCopy().withPcCopy()
+		stack2, _ := env2.unpack()
+		for range args {
+			stack2.Pop()
+		}
+		stack2.Push(big.NewInt(res))
+		return nextPcRes(env2), nil
+	})
+}
+
+// compareRanges decides an unsigned comparison if the ranges of the arguments allow it.
+func compareRanges(op vm.OpCode, args []EnvValue) (int64, bool) {
+	for _, arg := range args {
+		if arg.IsUnknown() {
+			return 0, false
+		}
+	}
+	lt := func(a, b EnvValue) (int64, bool) {
+		if a.max.Cmp(b.min) < 0 {
+			return 1, true
+		}
+		if b.max.Cmp(a.min) <= 0 {
+			return 0, true
+		}
+		return 0, false
+	}
+	switch op {
+	case vm.ISZERO:
+		if 0 < args[0].min.Sign() {
+			return 0, true
+		}
+		if args[0].max.Sign() == 0 {
+			return 1, true
+		}
+	case vm.LT:
+		return lt(args[0], args[1])
+	case vm.GT:
+		return lt(args[1], args[0])
+	case vm.EQ:
+		if args[0].max.Cmp(args[1].min) < 0 || args[1].max.Cmp(args[0].min) < 0 {
+			return 0, true
+		}
+		c0, ok0 := args[0].constant()
+		c1, ok1 := args[1].constant()
+		if ok0 && ok1 && c0.Cmp(c1) == 0 {
+			return 1, true
+		}
+	}
+	return 0, false
+}
+
+// envSourceBackwards determines if the stack element at the given index (before executing the instruction at the
+// given PC) was pushed by an instruction that reads an environment value.
+// It only follows instructions that shuffle the stack (i.e., pushes, duplications and swaps).
+func envSourceBackwards(contract *vm.Contract, ppcMap *prevPCMap, pc pcType, idx int, maxSteps int) (vm.OpCode, bool) {
+	_, op, found := stackSourceBackwards(contract, ppcMap, pc, idx, maxSteps, false)
+	if !found {
+		return 0, false
+	}
+	if _, isEnvOp := (Environment{}).values()[op]; isEnvOp {
+		return op, true
+	}
+	return 0, false
+}
+
+// stackSourceBackwards finds the last instruction that produced the stack element at the given index (before
+// executing the instruction at the given PC) and returns its PC and opcode.
+// It only follows instructions that shuffle the stack (i.e., pushes, duplications and swaps) and, if followMasks is
+// true, shifts and masks by pushed constants, which extract bits of a value (e.g., the function selector).
+// The returned instruction does not necessarily push a value (e.g., for a POP, it is not known where the element
+// came from).
+func stackSourceBackwards(contract *vm.Contract, ppcMap *prevPCMap, pc pcType, idx int, maxSteps int, followMasks bool) (pcType, vm.OpCode, bool) {
+	for i := 0; i < maxSteps; i++ {
+		var exists bool
+		pc, exists = ppcMap.getPrevPC(pc)
+		if !exists {
+			return 0, 0, false
+		}
+		op := contract.GetOp(uint64(pc))
+		switch {
+		case op == vm.JUMPDEST:
+			// This is a no-op.
+		case op >= vm.PUSH1 && op <= vm.PUSH32:
+			if idx == 0 {
+				return pc, op, true
+			}
+			idx--
+		case op >= vm.DUP1 && op <= vm.DUP16:
+			if idx == 0 {
+				idx = int(op - vm.DUP1)
+			} else {
+				idx--
+			}
+		case op >= vm.SWAP1 && op <= vm.SWAP16:
+			n := int(op-vm.SWAP1) + 1
+			if idx == 0 {
+				idx = n
+			} else if idx == n {
+				idx = 0
+			}
+		case followMasks && idx == 0 && (op == vm.SHR || op == vm.AND):
+			// The other operand needs to be pushed right before (e.g., "PUSH1 0xe0 SHR"), in which case we continue
+			// with the value below it.
+			ppc, exists := ppcMap.getPrevPC(pc)
+			if !exists {
+				return pc, op, true
+			}
+			if pushOp := contract.GetOp(uint64(ppc)); pushOp < vm.PUSH1 || vm.PUSH32 < pushOp {
+				return pc, op, true
+			}
+			pc = ppc
+		default:
+			if idx == 0 {
+				return pc, op, true
+			}
+			return 0, 0, false
+		}
+	}
+	return 0, 0, false
+}
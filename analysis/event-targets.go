@@ -0,0 +1,80 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// assertionFailedTopic is the topic of the following event type:
+// event AssertionFailed(string message);
+var assertionFailedTopic = common.HexToHash("0xb42604cb105a16c8f6db8a41e6b00c0c1b4826465e8bc504b3eb3e88b3e6a4a0")
+
+// eventTarget represents a targeted event.
+type eventTarget struct {
+	// cause is the failure cause that is reported if the event may be emitted.
+	cause string
+	// topics contains the expected topics (starting with topic0); nil entries match any topic.
+	topics []*big.Int
+}
+
+// mayMatch determines if the given (abstract) topics may match the target.
+func (t eventTarget) mayMatch(topics []*absVal) bool {
+	for i, exp := range t.topics {
+		if exp == nil {
+			continue
+		}
+		if len(topics) <= i {
+			return false
+		}
+		if !isTop(topics[i]) && topics[i].Cmp(exp) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mayEmitTargetEvent determines if the LOG instruction in the given state may emit a targeted event.
+// If so, it also returns the failure cause of the (first) matching event.
+func (a *constPropAnalyzer) mayEmitTargetEvent(op vm.OpCode, st absState) (string, bool) {
+	if st.isBot {
+		return "", false
+	}
+	numTopics := int(op - vm.LOG0)
+	var topics []*absVal
+	if st.stack.isTop || st.stack.len() < 2+numTopics {
+		// We don't know anything about the topics.
+		for i := 0; i < numTopics; i++ {
+			topics = append(topics, topVal())
+		}
+	} else {
+		for i := 0; i < numTopics; i++ {
+			topics = append(topics, st.stack.stack.Back(2+i))
+		}
+	}
+	for _, t := range a.analyzer.eventTargets {
+		if t.mayMatch(topics) {
+			return t.cause, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// AbstractOp is the abstract semantics of an opcode extension.
+type AbstractOp struct {
+	op absOp
+	// desc describes the semantics (e.g., "pop-push-top(1,1)"), which distinguishes the configurations that use
+	// them.
+	desc string
+	// hasEffects is true if the semantics continue execution, in which case pops, pushes and usesMem describe their
+	// stack and memory effects (which need to agree with the concrete semantics).
+	hasEffects   bool
+	pops, pushes int
+	usesMem      bool
+}
+
+// ConcreteStackOp returns the semantics of an operation that only modifies the stack. If all arguments are constants,
+// the concrete operation is executed; otherwise, the results are unknown.
+func ConcreteStackOp(pop, push int) AbstractOp {
+	return AbstractOp{
+		op:         makeStackOp(uint(pop), uint(push)),
+		desc:       fmt.Sprintf("concrete-stack(%v,%v)", pop, push),
+		hasEffects: true,
+		pops:       pop,
+		pushes:     push,
+	}
+}
+
+// PopPushTopOp returns the semantics of an operation whose results are always unknown.
+func PopPushTopOp(pop, push int) AbstractOp {
+	return AbstractOp{
+		op:         makePopPushTopOp(pop, push),
+		desc:       fmt.Sprintf("pop-push-top(%v,%v)", pop, push),
+		hasEffects: true,
+		pops:       pop,
+		pushes:     push,
+	}
+}
+
+// PopPushMemTopOp returns the semantics of an operation whose results are unknown and that writes unknown data to the
+// memory area given by the stack elements at the offset and size indices (e.g., the output area of a precompile).
+func PopPushMemTopOp(pop, push, memOffsetIdx, memSizeIdx int) AbstractOp {
+	return AbstractOp{
+		op: absOp{
+			valid:   true,
+			memSize: makeMemFn(memOffsetIdx, memSizeIdx),
+			exec:    makePopPushMemTopFn(pop, push, memOffsetIdx, memSizeIdx),
+		},
+		desc:       fmt.Sprintf("pop-push-mem-top(%v,%v,%v,%v)", pop, push, memOffsetIdx, memSizeIdx),
+		hasEffects: true,
+		pops:       pop,
+		pushes:     push,
+		usesMem:    true,
+	}
+}
+
+// HaltingOp returns the semantics of an operation that ends execution.
+func HaltingOp() AbstractOp {
+	return AbstractOp{op: emptyResOp, desc: "halting"}
+}
+
+// UnsupportedOp returns the semantics of an operation that may always lead to a failure.
+func UnsupportedOp() AbstractOp {
+	return AbstractOp{op: mayFailOp, desc: "unsupported"}
+}
+
+// ClobberingStorage returns semantics that additionally make the storage unknown (e.g., for operations that call
+// other contracts).
+func (o AbstractOp) ClobberingStorage() AbstractOp {
+	c := o
+	c.op.exec = clobberingStorage(o.op.exec)
+	c.desc = o.desc + "+clobbering-storage"
+	return c
+}
+
+// OpcodeExtension adds an opcode to the instructions of an analyzer.
+// Opcodes with immediate arguments (like PUSH1) are not supported.
+type OpcodeExtension struct {
+	Opcode   vm.OpCode
+	Concrete vm.OperationSpec
+	Abstract AbstractOp
+	// Version identifies the concrete semantics (i.e., the functions in Concrete), which cannot be compared otherwise.
+	// Extensions of the same opcode with different concrete semantics need different versions so that they do not
+	// share (saved) cached results.
+	Version string
+}
+
+// writeDigest writes all settings of the extension that may affect analysis results.
+func (e OpcodeExtension) writeDigest(w io.Writer) {
+	c := e.Concrete
+	fmt.Fprintf(w, "ext:%x:%q:%v:%v:%v:%v:%v:%v:%v:%v:%v:%v:%q\n", byte(e.Opcode), e.Version, c.ConstantGas, c.Pops, c.Pushes,
+		c.DynamicGas != nil, c.MemorySize != nil, c.Halts, c.Jumps, c.Writes, c.Reverts, c.Returns, e.Abstract.desc)
+}
+
+func (e OpcodeExtension) validate(fork Fork) error {
+	if fork.instructionSet()[e.Opcode].Valid {
+		return fmt.Errorf("opcode %#x is already defined in %v", byte(e.Opcode), fork)
+	}
+	if e.Concrete.Execute == nil {
+		return fmt.Errorf("missing concrete semantics for opcode %#x", byte(e.Opcode))
+	}
+	if e.Abstract.op.exec == nil {
+		return fmt.Errorf("missing abstract semantics for opcode %#x", byte(e.Opcode))
+	}
+	if e.Concrete.Pops < 0 || e.Concrete.Pushes < 0 {
+		return fmt.Errorf("invalid stack bounds for opcode %#x", byte(e.Opcode))
+	}
+	if a := e.Abstract; a.hasEffects {
+		if a.pops != e.Concrete.Pops || a.pushes != e.Concrete.Pushes {
+			return fmt.Errorf("abstract semantics for opcode %#x pop %v and push %v elements, but concrete ones pop %v and push %v",
+				byte(e.Opcode), a.pops, a.pushes, e.Concrete.Pops, e.Concrete.Pushes)
+		}
+		if a.usesMem != (e.Concrete.MemorySize != nil) {
+			return fmt.Errorf("abstract and concrete semantics for opcode %#x disagree on memory use", byte(e.Opcode))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// FailureSite is an instruction at which a possible failure may be reached.
+type FailureSite struct {
+	PC    uint64
+	Cause FailureCause
+	// TargetID identifies the reached target for causes that refer to one (e.g., the name of a target or a panic
+	// code). It is empty for target instructions, which are identified by their PC.
+	TargetID string
+	// Location is the part of the execution in which the possible failure was found.
+	Location Location
+	// Definite is true if the failure is reached by every execution that reaches the PC and the PC is reached on a
+	// path whose branches are all determined by the end of the prefix. Otherwise, the failure is only possibly
+	// reachable (e.g., since a branch condition is unknown or since the failure is caused by imprecision).
+	Definite bool
+}
+
+// isTargetCause determines if the given kind of failure refers to a target.
+func isTargetCause(k FailureKind) bool {
+	switch k {
+	case CauseReachedPanic, CauseReachedRevertError, CauseReachedEvent, CauseReachedTarget:
+		return true
+	default:
+		return false
+	}
+}
+
+// isDefiniteCause determines if a failure of the given kind is reached whenever its PC is reached.
+func isDefiniteCause(k FailureKind) bool {
+	return k == CauseInvalidOpcode || k == CauseReachedTargetInstruction
+}
+
+// failureSites returns the possible failures that were found by an exhaustive exploration (in the order they were
+// first found).
+func (f *fixpoint) failureSites() []FailureSite {
+	type siteKey struct {
+		pc    pcType
+		cause string
+	}
+	var sites []FailureSite
+	indices := map[siteKey]int{}
+	for i, res := range f.failures {
+		// A site may be found more than once if its state changes.
+		definite := isDefiniteCause(parseFailureCause(res.failureCause).Kind) && f.isDefinitelyReachable(f.failureLocs[i])
+		key := siteKey{pc: res.failurePC, cause: res.failureCause}
+		if idx, found := indices[key]; found {
+			sites[idx].Definite = sites[idx].Definite || definite
+			continue
+		}
+		indices[key] = len(sites)
+		sites = append(sites, newFailureSite(res, definite))
+	}
+	return sites
+}
+
+func newFailureSite(res result, definite bool) FailureSite {
+	r := newResult(res, "", CacheMiss)
+	site := FailureSite{
+		PC:       r.FailurePC,
+		Cause:    r.Cause,
+		Location: r.Location,
+		Definite: definite,
+	}
+	if isTargetCause(site.Cause.Kind) {
+		site.TargetID = site.Cause.Detail
+	}
+	return site
+}
+
+// isDefinitelyReachable determines if the state with the given location is reached whenever the end of the prefix is
+// reached. This is the case if there is a single entry state and the conditions of all branches on the path from the
+// entry state are known.
+func (f *fixpoint) isDefinitelyReachable(loc string) bool {
+	entryPCs := map[pcType]bool{}
+	for entry := range f.entryLocs {
+		entryPCs[f.locPCs[entry]] = true
+	}
+	if len(entryPCs) != 1 {
+		return false
+	}
+	for curr, exists := f.preds[loc]; exists; curr, exists = f.preds[curr] {
+		if f.analyzer.contract.GetOp(uint64(f.locPCs[curr])) != vm.JUMPI {
+			continue
+		}
+		// Since states only grow, a known condition has the same value in all states that reached the PC.
+		stack := f.states[curr].stack
+		if stack.isTop || stack.stack == nil || stack.len() < 2 || isTop(stack.stack.Back(1)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,205 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+)
+
+// fixpoint computes the abstract states that are reachable from a set of entry states.
+type fixpoint struct {
+	analyzer *constPropAnalyzer
+	concJt   concJumpTable
+	absJt    absJumpTable
+	states   map[string]absState
+	keys     map[pcType]map[string]bool
+	ppcMap   *prevPCMap
+	worklist []string
+	workset  map[string]pcType
+	// exhaustive is true if the exploration should continue after a possible failure was found.
+	exhaustive bool
+	// collect is true if the exploration ignores targets and only collects the reachable states (e.g., to find the
+	// reachable targets). incomplete is set if some states could not be followed (e.g., jumps to unknown
+	// destinations).
+	collect    bool
+	incomplete bool
+	// comparisons contains the constants that states compare with unknown values (nil if they are not recorded).
+	comparisons map[string][]MagicValue
+	// failures contains the possible failures that were found (in the order they were found) and failureLocs
+	// contains the locations of the corresponding states.
+	failures    []result
+	failureLocs []string
+	// preds maps the location of a state to the location of the state that first reached it (if it is not an entry
+	// state) and locPCs maps locations to PCs.
+	preds  map[string]string
+	locPCs map[string]pcType
+	// entryLocs contains the locations of the (non-bottom) entry states and succs maps the location of a state to
+	// the locations of its successors.
+	entryLocs map[string]bool
+	succs     map[string]map[string]bool
+	// failureLoc is the location of the state at which the first possible failure was found.
+	failureLoc string
+}
+
+func (a *constPropAnalyzer) newFixpoint(concJt concJumpTable, absJt absJumpTable) *fixpoint {
+	return &fixpoint{
+		analyzer:  a,
+		concJt:    concJt,
+		absJt:     absJt,
+		states:    map[string]absState{},
+		keys:      map[pcType]map[string]bool{},
+		ppcMap:    newPrevPCMap(),
+		workset:   map[string]pcType{},
+		preds:     map[string]string{},
+		locPCs:    map[string]pcType{},
+		entryLocs: map[string]bool{},
+		succs:     map[string]map[string]bool{},
+	}
+}
+
+// addNewStates joins the given entry states into the current ones and schedules the ones that changed.
+// The previous PC is nil for entry states that do not have a predecessor (e.g., at the beginning of the code).
+func (f *fixpoint) addNewStates(prevPC *pcType, newStates []pcAndSt) {
+	f.addStates(prevPC, "", newStates)
+}
+
+// addStates is like addNewStates, but also records the location of the previous state (empty for entry states).
+func (f *fixpoint) addStates(prevPC *pcType, prevLoc string, newStates []pcAndSt) {
+	for _, st := range newStates {
+		pc := st.pc
+		if prevPC != nil {
+			f.ppcMap.addPrevPC(pc, *prevPC)
+		}
+
+		newState := st.st.withStackCopy().withMemCopy()
+
+		stSize := -1
+		if !newState.isBot && !newState.stack.isTop && newState.stack.stack != nil {
+			stSize = newState.stack.len()
+		}
+		loc := fmt.Sprintf("%x:%x", pc, stSize)
+
+		oldState, exists := f.states[loc]
+		ks := f.keys[pc]
+		if ks == nil {
+			ks = map[string]bool{}
+		}
+		numDisjs := len(ks)
+		if !exists && f.analyzer.maxDisjuncts <= numDisjs {
+			loc = fmt.Sprintf("%x:%x", pc, -1)
+			oldState, exists = f.states[loc]
+		}
+		if !newState.isBot {
+			f.addEdge(prevLoc, loc)
+		}
+		if exists {
+			var diff bool
+			newState, diff = joinStates(oldState, newState)
+			if !diff || f.analyzer.useBoundedJoins {
+				continue
+			}
+		}
+
+		if !exists {
+			if prevLoc != "" {
+				f.preds[loc] = prevLoc
+			}
+			f.locPCs[loc] = pc
+		}
+		f.states[loc] = newState
+		ks[loc] = true
+		f.keys[pc] = ks
+		if _, ex := f.workset[loc]; !ex {
+			f.worklist = append(f.worklist, loc)
+			f.workset[loc] = pc
+		}
+	}
+}
+
+// addEdge records that the state with the given location is reached from the previous one (or is an entry state if
+// the previous location is empty).
+func (f *fixpoint) addEdge(prevLoc, loc string) {
+	if prevLoc == "" {
+		f.entryLocs[loc] = true
+		return
+	}
+	if f.succs[prevLoc] == nil {
+		f.succs[prevLoc] = map[string]bool{}
+	}
+	f.succs[prevLoc][loc] = true
+}
+
+func (f *fixpoint) popState() (absState, pcType, string) {
+	ret := f.worklist[0]
+	f.worklist = f.worklist[1:]
+	pc := f.workset[ret]
+	delete(f.workset, ret)
+	return f.states[ret], pc, ret
+}
+
+// run processes the worklist until a fixpoint is reached.
+// Unless the exploration is exhaustive, it stops at the first possible failure.
+func (f *fixpoint) run() (result, error) {
+	a := f.analyzer
+	for 0 < len(f.worklist) {
+		exceeded, err := a.budget.step(len(f.states))
+		if err != nil {
+			return mayFail(StepExecFail), err
+		}
+		if exceeded {
+			return a.analyzer.config.budgetExceeded(), nil
+		}
+		st, pc, loc := f.popState()
+		if st.isBot {
+			continue
+		}
+		if f.collect && a.layout.IsData(uint64(pc)) {
+			// Data is never executed (step only checks this if targets are not ignored).
+			continue
+		}
+		opcode := a.contract.GetOp(uint64(pc))
+		if f.comparisons != nil {
+			f.recordComparison(loc, pc, opcode, st)
+		}
+		res, stepErr := a.step(pc, f.ppcMap, st, f.concJt[opcode], opcode, f.absJt, f.collect)
+		if stepErr != nil {
+			return mayFail(StepExecFail), stepErr
+		}
+		if res.mayFail && f.collect {
+			f.incomplete = true
+			continue
+		}
+		if res.mayFail {
+			fail := mayFailAt(res.failureCause, pc)
+			if f.failureLoc == "" {
+				f.failureLoc = loc
+			}
+			if !f.exhaustive {
+				return fail, nil
+			}
+			f.failures = append(f.failures, fail)
+			f.failureLocs = append(f.failureLocs, loc)
+			continue
+		}
+		f.addStates(&pc, loc, res.postStates)
+	}
+	if 0 < len(f.failures) {
+		return f.failures[0], nil
+	}
+	return noFail(), nil
+}
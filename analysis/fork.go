@@ -0,0 +1,83 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// Fork is a hard fork, which determines the instructions that are available.
+type Fork int
+
+const (
+	ForkConstantinople Fork = iota + 1
+	ForkIstanbul
+	ForkBerlin
+	ForkLondon
+	ForkShanghai
+	ForkCancun
+)
+
+// DefaultFork is the fork that is used unless another one is configured.
+const DefaultFork = ForkIstanbul
+
+var forkNames = map[Fork]string{
+	ForkConstantinople: "constantinople",
+	ForkIstanbul:       "istanbul",
+	ForkBerlin:         "berlin",
+	ForkLondon:         "london",
+	ForkShanghai:       "shanghai",
+	ForkCancun:         "cancun",
+}
+
+// ParseFork returns the fork with the given (case-insensitive) name.
+func ParseFork(name string) (Fork, error) {
+	for f, n := range forkNames {
+		if strings.EqualFold(n, name) {
+			return f, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown fork '%v'", name)
+}
+
+func (f Fork) String() string {
+	if n, found := forkNames[f]; found {
+		return n
+	}
+	return fmt.Sprintf("fork(%d)", int(f))
+}
+
+// instructionSet returns the concrete instructions of the fork.
+func (f Fork) instructionSet() vm.JumpTable {
+	switch f {
+	case ForkConstantinople:
+		return vm.NewConstantinopleInstructionSet()
+	case ForkBerlin:
+		return vm.NewBerlinInstructionSet()
+	case ForkLondon:
+		return vm.NewLondonInstructionSet()
+	case ForkShanghai:
+		return vm.NewShanghaiInstructionSet()
+	case ForkCancun:
+		return vm.NewCancunInstructionSet()
+	}
+	return vm.NewIstanbulInstructionSet()
+}
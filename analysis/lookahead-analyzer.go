@@ -18,15 +18,21 @@
 package analysis
 
 import (
-	"encoding/binary"
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"hash"
-	"hash/fnv"
 	"math/big"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/ethereum/go-ethereum/params"
 
 	"github.com/practical-formal-methods/bran/vm"
@@ -34,6 +40,10 @@ import (
 
 var ReachedTargetInstructionFail = "reached-target-instruction"
 var ReachedAssertionFailed = "reached-assertion-failed"
+var ReachedPanicFail = "reached-panic"
+var ReachedRevertErrorFail = "reached-revert-error"
+var ReachedEventFail = "reached-event"
+var ReachedTargetFail = "reached-target"
 var InvalidOpcodeFail = "invalid-opcode"
 var UnsupportedOpcodeFail = "unsupported-opcode"
 var MemoryOverflowFail = "memory-overflow-failure"
@@ -45,25 +55,46 @@ var TopOffsetFail = "top-offset-failure"
 var PrefixComputationFail = "prefix-computation-failure"
 var StepExecFail = "step-execution-failure"
 var InternalFail = "internal-failure"
+var BudgetExceededFail = "budget-exceeded"
 
+// LookaheadAnalyzer determines if suffixes of executions can be ignored since they cannot reach a target.
+// It is safe for concurrent use if every worker uses its own session (see NewSession). Methods that take a call
+// number use the analyzer's default session.
 type LookaheadAnalyzer struct {
-	callInfos                  map[uint64]*callInfo
-	cachedResults              map[prefixHash]result
+	session *Session
+	cache   *resultCache
+	// suffixCache contains the results of suffix analyses by their entry states.
+	suffixCache *resultCache
+
+	// mu guards the configuration, the targets, the environment, the initial storage and the covered assertions.
+	// An analysis holds a read lock while it runs and therefore only uses unexported accessors.
+	mu                         sync.RWMutex
 	isTargetInstruction        map[string]bool
 	isCoveredAssertion         map[string]bool
-	lids                       map[string]string
-	coveredPaths               map[string]uint64
-	maxPrefixLen               int
-	useDummyAnalysis           bool
+	config                     Config
+	configHash                 [sha256.Size]byte
 	isTargetingAssertionFailed bool
-
-	numSuccess    uint64
-	numFail       uint64
-	numPrefixFail uint64
+	isTargetingPanics          bool
+	targetPanicCodes           map[uint64]bool
+	revertTargets              []revertTarget
+	eventTargets               []eventTarget
+	targets                    []Target
+	environment                Environment
+	// settings is a digest of the targets, the environment and the initial storage.
+	settings       [sha256.Size]byte
+	initialStorage *StorageSnapshot
+
+	numSuccess    atomic.Uint64
+	numFail       atomic.Uint64
+	numPrefixFail atomic.Uint64
+	numErrors     atomic.Uint64
+	time          atomic.Int64
+
+	// statsMu guards the failure causes and the covered paths.
+	statsMu       sync.Mutex
 	failureCauses map[string]uint64
-	numErrors     uint64
-	time          time.Duration
-	startTime     time.Time
+	lids          map[string]string
+	coveredPaths  map[string]uint64
 }
 
 type callInfo struct {
@@ -73,202 +104,542 @@ type callInfo struct {
 	prefixLen   int
 	prefixHash  hash.Hash32
 	summaryHash hash.Hash32
-	analyzer    *constPropAnalyzer
+	// prefixDigest and summaryDigest are collision-resistant versions of the prefix and summary hashes, which are
+	// used for caching.
+	prefixDigest  hash.Hash
+	summaryDigest hash.Hash
+	// isCreation is true if the code is creation code (with unknown constructor arguments).
+	isCreation bool
+	layout     *CodeLayout
+	// initialTransient is the transient storage at the beginning of the call.
+	initialTransient absStorage
+	// lastNestedPrefixLen is the length of the prefix when the last nested call was started (-1 if there was none).
+	lastNestedPrefixLen int
+	// parentDigest identifies the parent's prefix for nested calls (nil otherwise).
+	parentDigest []byte
 }
 
 type prefixHash uint32
 
-func NewLookaheadAnalyzer() *LookaheadAnalyzer {
-	return &LookaheadAnalyzer{
+// cacheKey identifies a cached result. It is a SHA-256 hash over the code hash, the full prefix, the summary, the
+// kind of call (and thus the initial storage), the targets (and other settings) and the configuration so that results
+// for different contracts or settings are never mixed. Keys do not depend on the process, which makes it possible to
+// persist the cache.
+type cacheKey [sha256.Size]byte
+
+// cacheKey computes the key of the result for the given call.
+// The caller is expected to hold the read lock of the analyzer.
+func (a *LookaheadAnalyzer) cacheKey(callNumber uint64, info *callInfo) cacheKey {
+	h := sha256.New()
+	h.Write(info.codeHash[:])
+	h.Write(info.prefixDigest.Sum(nil))
+	h.Write(info.summaryDigest.Sum(nil))
+	h.Write(a.settings[:])
+	h.Write(a.configHash[:])
+	kind := initialStateKind(callNumber, info)
+	h.Write([]byte{kind})
+	if kind == firstCallState {
+		// Only the first call starts from the storage snapshot.
+		h.Write(snapshotVersion(a.initialStorage).Bytes())
+	}
+	var key cacheKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// settingsChanged invalidates the cached results after the targets, the environment or the initial storage changed.
+// The caller is expected to hold the write lock of the analyzer.
+func (a *LookaheadAnalyzer) settingsChanged() {
+	a.settings = a.settingsDigest()
+	a.cache.clear()
+	a.suffixCache.clear()
+}
+
+// settingsDigest computes a digest of the settings that may affect analysis results (besides the configuration).
+// Covered assertions are not included since covering an assertion only removes targets (see RecordCoveredAssertion).
+// The caller is expected to hold the read or write lock of the analyzer.
+func (a *LookaheadAnalyzer) settingsDigest() [sha256.Size]byte {
+	var b strings.Builder
+	for _, loc := range sortedKeys(a.isTargetInstruction) {
+		fmt.Fprintf(&b, "instruction:%v\n", loc)
+	}
+	for _, t := range a.targets {
+		fmt.Fprintf(&b, "target:%v:%v", t.Name, t.Opcodes)
+		if t.CodeHash != nil {
+			fmt.Fprintf(&b, ":%x", *t.CodeHash)
+		}
+		if t.PC != nil {
+			fmt.Fprintf(&b, ":%x", *t.PC)
+		}
+		fmt.Fprintf(&b, ":%v\n", t.Predicates)
+	}
+	fmt.Fprintf(&b, "assertions:%v\n", a.isTargetingAssertionFailed)
+	for _, t := range a.eventTargets {
+		fmt.Fprintf(&b, "event:%v:%v\n", t.cause, t.topics)
+	}
+	for _, t := range a.revertTargets {
+		fmt.Fprintf(&b, "revert:%v:%x:%v\n", t.name, t.selector, t.message)
+	}
+	fmt.Fprintf(&b, "panics:%v", a.isTargetingPanics)
+	var codes []uint64
+	for code := range a.targetPanicCodes {
+		codes = append(codes, code)
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		return codes[i] < codes[j]
+	})
+	fmt.Fprintf(&b, ":%v\n", codes)
+	values := a.environment.values()
+	var ops []vm.OpCode
+	for op := range values {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i] < ops[j]
+	})
+	for _, op := range ops {
+		fmt.Fprintf(&b, "env:%v:%v:%v\n", op, values[op].min, values[op].max)
+	}
+	fmt.Fprintf(&b, "storage:%x\n", snapshotVersion(a.initialStorage))
+	return sha256.Sum256([]byte(b.String()))
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// NewLookaheadAnalyzer returns an analyzer with the given configuration.
+func NewLookaheadAnalyzer(cfg Config) (*LookaheadAnalyzer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg = cfg.withDefaults()
+	a := &LookaheadAnalyzer{
+		cache:               newResultCache(cfg.CacheBudget),
+		suffixCache:         newResultCache(cfg.CacheBudget),
 		failureCauses:       map[string]uint64{},
-		cachedResults:       map[prefixHash]result{},
 		isTargetInstruction: map[string]bool{},
 		isCoveredAssertion:  map[string]bool{},
 		lids:                map[string]string{},
 		coveredPaths:        map[string]uint64{},
-		callInfos:           map[uint64]*callInfo{},
-		maxPrefixLen:        MagicInt(8192),
-		useDummyAnalysis:    MagicBool(false),
+		config:              cfg,
+		configHash:          cfg.hash(),
+	}
+	a.settings = a.settingsDigest()
+	a.session = a.NewSession()
+	return a, nil
+}
+
+// Config returns the configuration of the analyzer (with defaults filled in).
+func (a *LookaheadAnalyzer) Config() Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	cfg := a.config
+	cfg.Extensions = append([]OpcodeExtension{}, cfg.Extensions...)
+	return cfg
+}
+
+// Fork returns the fork whose instructions are used by the analysis.
+func (a *LookaheadAnalyzer) Fork() Fork {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.config.Fork
+}
+
+// AddOpcodeExtension adds an opcode that is not defined in the analyzer's fork.
+// Extensions only affect this analyzer.
+func (a *LookaheadAnalyzer) AddOpcodeExtension(ext OpcodeExtension) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cfg := a.config
+	cfg.Extensions = append(append([]OpcodeExtension{}, cfg.Extensions...), ext)
+	if err := cfg.Validate(); err != nil {
+		return err
 	}
+	a.config = cfg
+	// The cache key includes the configuration hash, but old results are no longer needed.
+	a.configHash = cfg.hash()
+	a.cache.clear()
+	a.suffixCache.clear()
+	return nil
+}
+
+// instructionSet returns the concrete instructions of the fork including any extensions.
+func (a *LookaheadAnalyzer) instructionSet() vm.JumpTable {
+	jt := a.config.Fork.instructionSet()
+	for _, ext := range a.config.Extensions {
+		jt[ext.Opcode] = vm.NewOperation(ext.Concrete)
+	}
+	return jt
 }
 
 func (a *LookaheadAnalyzer) Start(callNumber uint64, code, codeHash []byte) {
-	a.startTimer()
-	defer a.stopTimer()
+	a.session.Start(callNumber, code, codeHash)
+}
 
-	if callNumber < 1 {
-		a.callInfos = map[uint64]*callInfo{}
-	}
-	addr := common.HexToAddress(MagicString("0x0123456789abcdef"))
+// StartNestedCall starts a nested call in the default session (see Session.StartNestedCall).
+func (a *LookaheadAnalyzer) StartNestedCall(callNumber, parentCallNumber uint64, code, codeHash []byte) error {
+	return a.session.StartNestedCall(callNumber, parentCallNumber, code, codeHash)
+}
+
+// StartCreation starts a call that executes creation code in the default session (see Session.StartCreation).
+func (a *LookaheadAnalyzer) StartCreation(callNumber uint64, code, codeHash []byte) {
+	a.session.StartCreation(callNumber, code, codeHash)
+}
+
+// AnalyzeCreation analyzes the constructor in the given creation code and determines the deployed runtime code.
+// If the constructor arguments are nil, they are unknown.
+func (a *LookaheadAnalyzer) AnalyzeCreation(code, codeHash, args []byte) (CreationResult, error) {
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	addr := a.config.ContractAddress
 	ch := common.BytesToHash(codeHash)
-	info := callInfo{
-		codeHash:    ch,
-		contract:    newDummyContract(addr, code, ch),
-		prefix:      map[int]pcType{},
-		prefixHash:  fnv.New32a(),
-		summaryHash: fnv.New32a(),
+	fullCode := append(append([]byte{}, code...), args...)
+	evm := newDummyEVM(a.instructionSet())
+	interpreter, ok := evm.Interpreter().(*vm.EVMInterpreter)
+	if !ok {
+		return CreationResult{}, fmt.Errorf("expected compatible EVM interpreter")
 	}
-	a.callInfos[callNumber] = &info
+	analyzer := newConstPropAnalyzer(newDummyContract(addr, fullCode, ch), ch, AnalyzeCodeLayout(fullCode), interpreter, a)
+	analyzer.hasUnknownArgs = args == nil
+	analyzer.initialStorage = NewStorageSnapshot(nil)
+	return analyzer.analyzeCreation()
 }
 
 func (a *LookaheadAnalyzer) AppendPrefixSummary(callNumber, callNumberToSummarize uint64) {
-	a.startTimer()
-	defer a.stopTimer()
-
-	info := a.callInfos[callNumber]
-	if info == nil {
-		return
-	}
-	sumInfo := a.callInfos[callNumberToSummarize]
-	if sumInfo == nil {
-		return
-	}
-	prefixSum := sumInfo.prefixHash.Sum32()
-	prefixBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(prefixBytes, prefixSum)
-	info.summaryHash.Write(prefixBytes)
-	summarySum := sumInfo.summaryHash.Sum32()
-	summaryBytes := make([]byte, 4)
-	binary.LittleEndian.PutUint32(summaryBytes, summarySum)
-	info.summaryHash.Write(summaryBytes)
+	a.session.AppendPrefixSummary(callNumber, callNumberToSummarize)
 }
 
 func (a *LookaheadAnalyzer) AppendPrefixInstruction(callNumber uint64, pc uint64) {
-	a.startTimer()
-	defer a.stopTimer()
-
-	info := a.callInfos[callNumber]
-	if info == nil {
-		return
-	}
-	prefixLen := info.prefixLen
-	if prefixLen < a.maxPrefixLen {
-		// We stop recording if it becomes too long.
-		info.prefix[prefixLen] = pcType(pc)
-	}
-	info.prefixLen++
-	b := make([]byte, 8)
-	binary.LittleEndian.PutUint64(b, pc)
-	info.prefixHash.Write(b)
+	a.session.AppendPrefixInstruction(callNumber, pc)
 }
 
 func (a *LookaheadAnalyzer) CurrentPathID() string {
-	info := a.callInfos[0]
-	if info == nil {
-		return ""
-	}
-	pHash := prefixHash(info.prefixHash.Sum32())
-	sHash := info.summaryHash.Sum32()
-	return fmt.Sprintf("%x+%x", pHash, sHash)
+	return a.session.CurrentPathID()
 }
 
 func (a *LookaheadAnalyzer) CanIgnoreSuffix(callNumber uint64) (canIgnore, avoidRetry bool, justification, prefixId string, err error) {
-	a.startTimer()
-	defer a.stopTimer()
-
-	info := a.callInfos[callNumber]
-	if info == nil {
-		return false, false, "", "", fmt.Errorf("analysis not yet started")
-	}
-
-	if a.useDummyAnalysis {
-		return false, false, "", "", nil
-	}
-
-	if a.maxPrefixLen < info.prefixLen {
-		return false, true, "", "", fmt.Errorf("overly long prefix")
-	}
+	return a.session.CanIgnoreSuffix(callNumber)
+}
 
-	pHash := prefixHash(info.prefixHash.Sum32())
-	sHash := info.summaryHash.Sum32()
-	pid := fmt.Sprintf("%x:%x", pHash, sHash)
+func (a *LookaheadAnalyzer) CanIgnoreSuffixContext(ctx context.Context, callNumber uint64) (canIgnore, avoidRetry bool, justification, prefixId string, err error) {
+	return a.session.CanIgnoreSuffixContext(ctx, callNumber)
+}
 
-	if cachedRes, found := a.cachedResults[pHash]; found {
-		if cachedRes.mayFail {
-			return false, cachedRes.avoidRetry, cachedRes.failureCause, pid, nil
-		}
-		a.recordSuccess()
-		return true, cachedRes.avoidRetry, "", pid, nil
-	}
+func (a *LookaheadAnalyzer) AnalyzeSuffix(ctx context.Context, callNumber uint64) (Result, error) {
+	return a.session.AnalyzeSuffix(ctx, callNumber)
+}
 
-	if info.analyzer == nil {
-		evm := newDummyEVM()
-		interpreter, ok := evm.Interpreter().(*vm.EVMInterpreter)
-		if !ok {
-			return false, true, "", pid, fmt.Errorf("expected compatible EVM interpreter")
-		}
-		info.analyzer = newConstPropAnalyzer(info.contract, info.codeHash, interpreter, a)
-	}
+func (a *LookaheadAnalyzer) FailureSites(ctx context.Context, callNumber uint64) ([]FailureSite, error) {
+	return a.session.FailureSites(ctx, callNumber)
+}
 
-	res, prefixErr, suffixErr := info.analyzer.Analyze(info.prefix)
-	if prefixErr != nil {
-		a.recordError()
-		return false, true, "", pid, prefixErr
-	}
-	if suffixErr != nil {
-		a.recordError()
-		return false, false, "", pid, suffixErr
-	}
+func (a *LookaheadAnalyzer) MagicValues(ctx context.Context, callNumber uint64) ([]MagicValue, error) {
+	return a.session.MagicValues(ctx, callNumber)
+}
 
-	// We cache both kinds of results, but not errors.
-	a.cachedResults[pHash] = result{
-		mayFail:      res.mayFail,
-		failureCause: res.failureCause,
-		avoidRetry:   res.avoidRetry,
-	}
+func (a *LookaheadAnalyzer) ReachableTargets(callNumber uint64) (targets []ReachableTarget, complete bool, err error) {
+	return a.session.ReachableTargets(callNumber)
+}
 
-	if res.mayFail {
-		a.recordFailure(res.failureCause, res.avoidRetry)
-		return false, res.avoidRetry, res.failureCause, pid, nil
-	}
+func (a *LookaheadAnalyzer) BranchInputs(ctx context.Context, callNumber uint64) (branches []BranchInputs, complete bool, err error) {
+	return a.session.BranchInputs(ctx, callNumber)
+}
 
-	a.recordSuccess()
-	return true, false, "", pid, nil
+// CodeLayout returns the layout of the code that is executed by the given call in the default session.
+func (a *LookaheadAnalyzer) CodeLayout(callNumber uint64) *CodeLayout {
+	return a.session.CodeLayout(callNumber)
 }
 
 func (a *LookaheadAnalyzer) IsCoveredAssertion(codeHash common.Hash, pc uint64) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.isCoveredAssertionAt(codeHash, pc)
+}
+
+func (a *LookaheadAnalyzer) isCoveredAssertionAt(codeHash common.Hash, pc uint64) bool {
 	return a.isCoveredAssertion[fmt.Sprintf("%032x:%x", codeHash, pc)]
 }
 
 func (a *LookaheadAnalyzer) RecordCoveredAssertion(codeHash []byte, pc uint64) {
-	a.isCoveredAssertion[fmt.Sprintf("%032x:%x", codeHash, pc)] = true
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	loc := fmt.Sprintf("%032x:%x", codeHash, pc)
+	if !a.isCoveredAssertion[loc] {
+		// Covered assertions are no longer reported. Since this only removes targets, results without a possible
+		// failure remain valid.
+		a.isCoveredAssertion[loc] = true
+		a.cache.removeMayFail()
+		a.suffixCache.removeMayFail()
+	}
 }
 
 func (a *LookaheadAnalyzer) AddTargetInstruction(codeHash []byte, pc uint64) {
-	loc := fmt.Sprintf("%032x:%x", codeHash, pc)
-	a.isTargetInstruction[loc] = true
+	a.AddTargetLocation(fmt.Sprintf("%032x:%x", codeHash, pc))
 }
 
 func (a *LookaheadAnalyzer) AddTargetLocation(loc string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.isTargetInstruction[loc] = true
+	a.settingsChanged()
 }
 
 func (a *LookaheadAnalyzer) HasTargetInstructions() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.hasTargetInstructions()
+}
+
+func (a *LookaheadAnalyzer) hasTargetInstructions() bool {
 	return 0 < len(a.isTargetInstruction)
 }
 
+// AddTarget registers a target that is described by opcodes, a location and predicates on the abstract state.
+func (a *LookaheadAnalyzer) AddTarget(t Target) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.targets = append(a.targets, t)
+	a.settingsChanged()
+}
+
+// AddTargetSpec registers a target in the textual representation accepted by ParseTarget.
+func (a *LookaheadAnalyzer) AddTargetSpec(spec string) error {
+	t, err := ParseTarget(spec)
+	if err != nil {
+		return err
+	}
+	a.AddTarget(t)
+	return nil
+}
+
+func (a *LookaheadAnalyzer) HasTargets() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.hasTargets()
+}
+
+func (a *LookaheadAnalyzer) hasTargets() bool {
+	return 0 < len(a.targets)
+}
+
 func (a *LookaheadAnalyzer) IsTargetingAssertionFailed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.isTargetingAssertionFailed
 }
 
 func (a *LookaheadAnalyzer) TargetAssertionFailed() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.isTargetingAssertionFailed {
+		return
+	}
 	a.isTargetingAssertionFailed = true
+	a.eventTargets = append(a.eventTargets, eventTarget{
+		cause:  ReachedAssertionFailed,
+		topics: []*big.Int{assertionFailedTopic.Big()},
+	})
+	a.settingsChanged()
+}
+
+// AddTargetEvent targets events with the given name and topic0 (emitted by any of LOG1 to LOG4).
+// Further topics can be constrained by passing their expected values, where nil matches any value.
+func (a *LookaheadAnalyzer) AddTargetEvent(name string, topic0 common.Hash, topics ...*common.Hash) {
+	a.AddTargetAnonymousEvent(name, append([]*common.Hash{&topic0}, topics...)...)
+}
+
+// AddTargetEventSignature targets events with the given signature (e.g., "Transfer(address,address,uint256)").
+// Further topics can be constrained as for AddTargetEvent.
+func (a *LookaheadAnalyzer) AddTargetEventSignature(signature string, topics ...*common.Hash) {
+	a.AddTargetEvent(signature, crypto.Keccak256Hash([]byte(signature)), topics...)
+}
+
+// AddTargetAnonymousEvent targets anonymous events (emitted by any of LOG0 to LOG4) with the given name.
+// The topics (starting with the first one) can be constrained as for AddTargetEvent.
+func (a *LookaheadAnalyzer) AddTargetAnonymousEvent(name string, topics ...*common.Hash) {
+	t := eventTarget{
+		cause: fmt.Sprintf("%v(%v)", ReachedEventFail, name),
+	}
+	for _, topic := range topics {
+		var exp *big.Int
+		if topic != nil {
+			exp = topic.Big()
+		}
+		t.topics = append(t.topics, exp)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.eventTargets = append(a.eventTargets, t)
+	a.settingsChanged()
+}
+
+func (a *LookaheadAnalyzer) HasTargetEvents() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.hasTargetEvents()
+}
+
+func (a *LookaheadAnalyzer) hasTargetEvents() bool {
+	return 0 < len(a.eventTargets)
+}
+
+// SetEnvironment declares what the analysis may assume about block, transaction and account values.
+// Previously cached results are discarded since they may have been computed under different assumptions.
+func (a *LookaheadAnalyzer) SetEnvironment(env Environment) error {
+	if err := env.Validate(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.environment = env
+	a.settingsChanged()
+	return nil
+}
+
+func (a *LookaheadAnalyzer) Environment() Environment {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.environment
+}
+
+// SetInitialStorage sets the storage of the analyzed contract before the first call in a sequence.
+// Passing nil makes the initial storage unknown.
+// Previously cached results are discarded if the snapshot has a different version.
+func (a *LookaheadAnalyzer) SetInitialStorage(snapshot *StorageSnapshot) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	changed := snapshotVersion(a.initialStorage) != snapshotVersion(snapshot)
+	a.initialStorage = snapshot
+	if changed {
+		a.settingsChanged()
+	}
+}
+
+// InitialStorageVersion returns the version of the current storage snapshot (or the zero hash if there is none).
+func (a *LookaheadAnalyzer) InitialStorageVersion() common.Hash {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return snapshotVersion(a.initialStorage)
+}
+
+func snapshotVersion(snapshot *StorageSnapshot) common.Hash {
+	if snapshot == nil {
+		return common.Hash{}
+	}
+	return snapshot.Version()
+}
+
+// TargetPanics makes the analysis report reverts with a Panic(uint256) payload (as used by Solidity since
+// version 0.8 for failing assertions, arithmetic overflows, etc.).
+// If no codes are given, all panic codes are targeted. Otherwise, only the given codes are targeted.
+func (a *LookaheadAnalyzer) TargetPanics(codes ...uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.isTargetingPanics = true
+	a.targetPanicCodes = map[uint64]bool{}
+	for _, code := range codes {
+		a.targetPanicCodes[code] = true
+	}
+	a.settingsChanged()
+}
+
+func (a *LookaheadAnalyzer) IsTargetingPanics() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.isTargetingPanics
+}
+
+// IsTargetPanicCode determines if reverts with the given panic code are targeted.
+func (a *LookaheadAnalyzer) IsTargetPanicCode(code *big.Int) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.isTargetPanicCode(code)
+}
+
+func (a *LookaheadAnalyzer) isTargetPanicCode(code *big.Int) bool {
+	if !a.isTargetingPanics {
+		return false
+	}
+	if len(a.targetPanicCodes) == 0 {
+		return true
+	}
+	return code.IsUint64() && a.targetPanicCodes[code.Uint64()]
+}
+
+// AddTargetErrorString targets reverts with an Error(string) payload whose message matches the given regular
+// expression.
+func (a *LookaheadAnalyzer) AddTargetErrorString(pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.revertTargets = append(a.revertTargets, revertTarget{
+		name:     fmt.Sprintf("Error(%q)", pattern),
+		selector: errorStringSelector,
+		message:  re,
+	})
+	a.settingsChanged()
+	return nil
+}
+
+// AddTargetCustomError targets reverts with the custom error that has the given name and 4-byte selector.
+func (a *LookaheadAnalyzer) AddTargetCustomError(name string, selector [4]byte) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.revertTargets = append(a.revertTargets, revertTarget{
+		name:     name,
+		selector: selector[:],
+	})
+	a.settingsChanged()
+}
+
+// AddTargetCustomErrorSignature targets reverts with the custom error that has the given signature
+// (e.g., "Unauthorized()").
+func (a *LookaheadAnalyzer) AddTargetCustomErrorSignature(signature string) {
+	var selector [4]byte
+	copy(selector[:], crypto.Keccak256([]byte(signature)))
+	a.AddTargetCustomError(signature, selector)
+}
+
+func (a *LookaheadAnalyzer) HasRevertTargets() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.hasRevertTargets()
+}
+
+func (a *LookaheadAnalyzer) hasRevertTargets() bool {
+	return 0 < len(a.revertTargets)
 }
 
 func (a *LookaheadAnalyzer) IsTargetInstruction(codeHash common.Hash, pc uint64) bool {
-	id := fmt.Sprintf("%032x:%x", codeHash, pc)
-	return a.isTargetInstruction[id]
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.isTargetInstructionAt(codeHash, pc)
 }
 
-func (a *LookaheadAnalyzer) startTimer() {
-	a.startTime = time.Now()
+func (a *LookaheadAnalyzer) isTargetInstructionAt(codeHash common.Hash, pc uint64) bool {
+	id := fmt.Sprintf("%032x:%x", codeHash, pc)
+	return a.isTargetInstruction[id]
 }
 
-func (a *LookaheadAnalyzer) stopTimer() {
-	a.time += time.Now().Sub(a.startTime)
+// recordTime adds the time since the given start time to the analysis time.
+func (a *LookaheadAnalyzer) recordTime(start time.Time) {
+	a.time.Add(int64(time.Since(start)))
 }
 
 func (a *LookaheadAnalyzer) RecordCoveredPath(pathId, lid string) {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
 	if _, exists := a.lids[pathId]; !exists {
 		a.lids[pathId] = lid
 		a.coveredPaths[lid]++
@@ -276,39 +647,83 @@ func (a *LookaheadAnalyzer) RecordCoveredPath(pathId, lid string) {
 }
 
 func (a *LookaheadAnalyzer) recordSuccess() {
-	a.numSuccess++
+	a.numSuccess.Add(1)
 }
 
 func (a *LookaheadAnalyzer) recordFailure(cause string, inPrefix bool) {
 	if inPrefix {
-		a.numPrefixFail++
+		a.numPrefixFail.Add(1)
 	} else {
-		a.numFail++
+		a.numFail.Add(1)
 	}
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
 	a.failureCauses[cause]++
 }
 
 func (a *LookaheadAnalyzer) recordError() {
-	a.numErrors++
+	a.numErrors.Add(1)
 }
 
 func (a *LookaheadAnalyzer) NumSuccess() uint64 {
-	return a.numSuccess
+	return a.numSuccess.Load()
 }
 
 func (a *LookaheadAnalyzer) NumFail() uint64 {
-	return a.numFail
+	return a.numFail.Load()
 }
 
 func (a *LookaheadAnalyzer) NumPrefixFail() uint64 {
-	return a.numPrefixFail
+	return a.numPrefixFail.Load()
 }
 
 func (a *LookaheadAnalyzer) NumErrors() uint64 {
-	return a.numErrors
+	return a.numErrors.Load()
+}
+
+// CacheHits returns the number of results that were found in the cache.
+func (a *LookaheadAnalyzer) CacheHits() uint64 {
+	return a.cache.hits.Load()
+}
+
+// CacheMisses returns the number of results that were not found in the cache.
+func (a *LookaheadAnalyzer) CacheMisses() uint64 {
+	return a.cache.misses.Load()
+}
+
+// CacheEvictions returns the number of results that were evicted from the cache to stay within the budget.
+func (a *LookaheadAnalyzer) CacheEvictions() uint64 {
+	return a.cache.evictions.Load()
+}
+
+// CacheSize returns the number of cached results and the estimated number of bytes that they use.
+func (a *LookaheadAnalyzer) CacheSize() (int, int) {
+	return a.cache.sizes()
+}
+
+// SuffixCacheHits returns the number of suffix analyses that were avoided since a different prefix already reached
+// the same entry states.
+func (a *LookaheadAnalyzer) SuffixCacheHits() uint64 {
+	return a.suffixCache.hits.Load()
+}
+
+// SuffixCacheMisses returns the number of suffix analyses whose entry states were not found in the cache.
+func (a *LookaheadAnalyzer) SuffixCacheMisses() uint64 {
+	return a.suffixCache.misses.Load()
+}
+
+// SuffixCacheHitRate returns the fraction of suffix analyses that were avoided (or 0 if there were none).
+func (a *LookaheadAnalyzer) SuffixCacheHitRate() float64 {
+	hits, misses := a.SuffixCacheHits(), a.SuffixCacheMisses()
+	if hits+misses == 0 {
+		return 0
+	}
+	return float64(hits) / float64(hits+misses)
 }
 
 func (a *LookaheadAnalyzer) CoveredPathsPerLID() map[string]uint64 {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
 	cps := map[string]uint64{}
 	for lid, cnt := range a.coveredPaths {
 		cps[lid] = cnt
@@ -317,10 +732,12 @@ func (a *LookaheadAnalyzer) CoveredPathsPerLID() map[string]uint64 {
 }
 
 func (a *LookaheadAnalyzer) Time() time.Duration {
-	return a.time
+	return time.Duration(a.time.Load())
 }
 
 func (a *LookaheadAnalyzer) FailureCauses() map[string]uint64 {
+	a.statsMu.Lock()
+	defer a.statsMu.Unlock()
 	fcs := map[string]uint64{}
 	for cause, cnt := range a.failureCauses {
 		fcs[cause] = cnt
@@ -346,11 +763,11 @@ func newDummyContract(address common.Address, code []byte, codeHash common.Hash)
 }
 
 // newDummyEVM creates a EVM object we can use to run code.
-func newDummyEVM() *vm.EVM {
+func newDummyEVM(jt vm.JumpTable) *vm.EVM {
 	ctx := vm.Context{
 		BlockNumber: big.NewInt(1),
 	}
-	evmConfig := vm.Config{JumpTable: vm.NewConstantinopleInstructionSet()}
+	evmConfig := vm.Config{JumpTable: jt}
 	chainConfig := &params.ChainConfig{
 		ChainID:             big.NewInt(1),
 		HomesteadBlock:      big.NewInt(0),
@@ -18,11 +18,21 @@
 package analysis
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"math/big"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/practical-formal-methods/bran/vm"
 )
 
 var tests = []struct {
@@ -173,6 +183,14 @@ var tests = []struct {
 	},
 }
 
+func newTestAnalyzer(t *testing.T, cfg Config) *LookaheadAnalyzer {
+	a, err := NewLookaheadAnalyzer(cfg)
+	if err != nil {
+		t.Fatalf("unexpected invalid configuration: %v", err)
+	}
+	return a
+}
+
 func TestConstantPropagation(t *testing.T) {
 	for _, tc := range tests {
 		code, err := hex.DecodeString(tc.code)
@@ -180,7 +198,7 @@ func TestConstantPropagation(t *testing.T) {
 			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
 			continue
 		}
-		a := NewLookaheadAnalyzer()
+		a := newTestAnalyzer(t, Config{})
 		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
 		for _, pc := range tc.prefix {
 			a.AppendPrefixInstruction(1, pc)
@@ -207,3 +225,1529 @@ func TestConstantPropagation(t *testing.T) {
 		}
 	}
 }
+
+func TestEnvironment(t *testing.T) {
+	envTests := []struct {
+		name      string
+		code      string
+		prefix    []uint64
+		env       Environment
+		canIgnore bool
+	}{
+		{
+			name:      "non-payable-unknown",
+			code:      "3415600757fe005b00",
+			prefix:    []uint64{0},
+			canIgnore: false,
+		},
+		{
+			name:      "non-payable-constant",
+			code:      "3415600757fe005b00",
+			prefix:    []uint64{0},
+			env:       Environment{CallValue: ConstantValue(big.NewInt(0))},
+			canIgnore: true,
+		},
+		{
+			name:      "timestamp-unknown",
+			code:      "60644211600957fe005b00",
+			prefix:    []uint64{0},
+			canIgnore: false,
+		},
+		{
+			name:      "timestamp-range",
+			code:      "60644211600957fe005b00",
+			prefix:    []uint64{0},
+			env:       Environment{Timestamp: RangeValue(big.NewInt(1000), big.NewInt(2000))},
+			canIgnore: true,
+		},
+	}
+	for _, tc := range envTests {
+		code, err := hex.DecodeString(tc.code)
+		if err != nil {
+			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
+			continue
+		}
+		a := newTestAnalyzer(t, Config{})
+		if err := a.SetEnvironment(tc.env); err != nil {
+			t.Errorf("[%v] unexpected invalid environment: %v", tc.name, err)
+			continue
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		for _, pc := range tc.prefix {
+			a.AppendPrefixInstruction(1, pc)
+		}
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore {
+			t.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.name, tc.canIgnore, canIgnore, cause)
+		}
+	}
+
+	invalid := Environment{Number: RangeValue(big.NewInt(2), big.NewInt(1))}
+	if err := newTestAnalyzer(t, Config{}).SetEnvironment(invalid); err == nil {
+		t.Errorf("expected empty range to be rejected")
+	}
+}
+
+func TestInitialStorage(t *testing.T) {
+	alloc := `{"alloc": {"0x0000000000000000000000000123456789abcdef": {"balance": "0x0", "storage": {"0x00": "0x01"}}}}`
+	snapshot, err := ReadStorageSnapshot(strings.NewReader(alloc), common.HexToAddress("0x0123456789abcdef"))
+	if err != nil {
+		t.Fatalf("unexpected error reading storage snapshot: %v", err)
+	}
+	storageTests := []struct {
+		name       string
+		code       string
+		callNumber uint64
+		snapshot   *StorageSnapshot
+		canIgnore  bool
+	}{
+		{
+			name:       "unknown-storage",
+			code:       "600054600857fe005b00",
+			callNumber: 0,
+			canIgnore:  false,
+		},
+		{
+			name:       "initial-storage",
+			code:       "600054600857fe005b00",
+			callNumber: 0,
+			snapshot:   snapshot,
+			canIgnore:  true,
+		},
+		{
+			name:       "later-call",
+			code:       "600054600857fe005b00",
+			callNumber: 1,
+			snapshot:   snapshot,
+			canIgnore:  false,
+		},
+		{
+			name:       "overwritten-storage",
+			code:       "600035600055600054600e57fe005b00",
+			callNumber: 0,
+			snapshot:   snapshot,
+			canIgnore:  false,
+		},
+	}
+	for _, tc := range storageTests {
+		code, err := hex.DecodeString(tc.code)
+		if err != nil {
+			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
+			continue
+		}
+		a := newTestAnalyzer(t, Config{})
+		a.SetInitialStorage(tc.snapshot)
+		a.Start(tc.callNumber, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(tc.callNumber, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(tc.callNumber)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore {
+			t.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.name, tc.canIgnore, canIgnore, cause)
+		}
+	}
+
+	// The result for the first call must not be reused for a later call with the same prefix.
+	code, _ := hex.DecodeString("600054600857fe005b00")
+	codeHash := crypto.Keccak256Hash(code).Bytes()
+	a := newTestAnalyzer(t, Config{})
+	a.SetInitialStorage(snapshot)
+	for _, tc := range []struct {
+		callNumber uint64
+		canIgnore  bool
+	}{{0, true}, {1, false}} {
+		a.Start(tc.callNumber, code, codeHash)
+		a.AppendPrefixInstruction(tc.callNumber, 0)
+		if canIgnore, _, cause, _, err := a.CanIgnoreSuffix(tc.callNumber); err != nil || canIgnore != tc.canIgnore {
+			t.Errorf("[call %v] expected analysis result %v, but got %v (failure cause '%v', error: %v)", tc.callNumber, tc.canIgnore, canIgnore, cause, err)
+		}
+	}
+
+	other := NewStorageSnapshot(map[common.Hash]common.Hash{{}: common.BigToHash(big.NewInt(2))})
+	if other.Version() == snapshot.Version() {
+		t.Errorf("expected snapshots with different contents to have different versions")
+	}
+}
+
+func TestPanicTargets(t *testing.T) {
+	// Reverts with Panic(0x11) (i.e., an arithmetic overflow).
+	code, _ := hex.DecodeString("634e487b7160e01b600052601160045260246000fd")
+	panicTests := []struct {
+		name         string
+		codes        []uint64
+		targeting    bool
+		canIgnore    bool
+		failureCause string
+	}{
+		{
+			name:      "not-targeting",
+			canIgnore: true,
+		},
+		{
+			name:         "all-codes",
+			targeting:    true,
+			canIgnore:    false,
+			failureCause: "reached-panic(0x11)",
+		},
+		{
+			name:         "overflow",
+			codes:        []uint64{PanicAssert, PanicArithmetic},
+			targeting:    true,
+			canIgnore:    false,
+			failureCause: "reached-panic(0x11)",
+		},
+		{
+			name:      "assert-only",
+			codes:     []uint64{PanicAssert},
+			targeting: true,
+			canIgnore: true,
+		},
+	}
+	for _, tc := range panicTests {
+		a := newTestAnalyzer(t, Config{})
+		if tc.targeting {
+			a.TargetPanics(tc.codes...)
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore {
+			t.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.name, tc.canIgnore, canIgnore, cause)
+			continue
+		}
+		if cause != tc.failureCause {
+			t.Errorf("[%v] expected failure cause '%v', but got '%v'", tc.name, tc.failureCause, cause)
+		}
+	}
+}
+
+func TestRevertErrorTargets(t *testing.T) {
+	// Reverts with Error("unauth").
+	errorStringCode := "6308c379a060e01b60005260206004526006602452" +
+		"7f756e61757468000000000000000000000000000000000000000000000000000060445260646000fd"
+	// Reverts with an Error(string) payload whose offset (2^64 - 4) overflows when it is added to the selector size.
+	overflowingCode := "6308c379a060e01b60005267fffffffffffffffc6004526006602452" +
+		"7f756e61757468000000000000000000000000000000000000000000000000000060445260646000fd"
+	// Reverts with Unauthorized().
+	customErrorCode := fmt.Sprintf("63%x60e01b60005260046000fd", crypto.Keccak256([]byte("Unauthorized()"))[:4])
+	revertTests := []struct {
+		name         string
+		code         string
+		errorString  string
+		customError  string
+		canIgnore    bool
+		failureCause string
+	}{
+		{
+			name:         "matching-message",
+			code:         errorStringCode,
+			errorString:  "^un",
+			canIgnore:    false,
+			failureCause: `reached-revert-error(Error("^un"))`,
+		},
+		{
+			name:        "other-message",
+			code:        errorStringCode,
+			errorString: "^Ownable",
+			canIgnore:   true,
+		},
+		{
+			// The message is unknown, so the error may match.
+			name:         "overflowing-offset",
+			code:         overflowingCode,
+			errorString:  "^Ownable",
+			canIgnore:    false,
+			failureCause: `reached-revert-error(Error("^Ownable"))`,
+		},
+		{
+			name:         "matching-custom-error",
+			code:         customErrorCode,
+			customError:  "Unauthorized()",
+			canIgnore:    false,
+			failureCause: "reached-revert-error(Unauthorized())",
+		},
+		{
+			name:        "other-custom-error",
+			code:        customErrorCode,
+			customError: "InsufficientBalance(uint256,uint256)",
+			canIgnore:   true,
+		},
+	}
+	for _, tc := range revertTests {
+		code, err := hex.DecodeString(tc.code)
+		if err != nil {
+			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
+			continue
+		}
+		a := newTestAnalyzer(t, Config{})
+		if tc.errorString != "" {
+			if err := a.AddTargetErrorString(tc.errorString); err != nil {
+				t.Errorf("[%v] unexpected invalid pattern: %v", tc.name, err)
+				continue
+			}
+		}
+		if tc.customError != "" {
+			a.AddTargetCustomErrorSignature(tc.customError)
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore {
+			t.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.name, tc.canIgnore, canIgnore, cause)
+			continue
+		}
+		if cause != tc.failureCause {
+			t.Errorf("[%v] expected failure cause '%v', but got '%v'", tc.name, tc.failureCause, cause)
+		}
+	}
+}
+
+func TestEventTargets(t *testing.T) {
+	transfer := "Transfer(address,address,uint256)"
+	recipient := common.HexToHash("0x00000000000000000000000000000000000000000000000000000000000000aa")
+	other := common.HexToHash("0x00000000000000000000000000000000000000000000000000000000000000bb")
+	// Emits Transfer(msg.sender, 0xaa, 0).
+	code, _ := hex.DecodeString(fmt.Sprintf("73%x337f%x60006000a300", recipient[12:], crypto.Keccak256([]byte(transfer))))
+	eventTests := []struct {
+		name         string
+		topics       []*common.Hash
+		assertion    bool
+		canIgnore    bool
+		failureCause string
+	}{
+		{
+			name:         "any-transfer",
+			canIgnore:    false,
+			failureCause: "reached-event(Transfer(address,address,uint256))",
+		},
+		{
+			name:         "transfer-to-recipient",
+			topics:       []*common.Hash{nil, &recipient},
+			canIgnore:    false,
+			failureCause: "reached-event(Transfer(address,address,uint256))",
+		},
+		{
+			name:      "transfer-to-other",
+			topics:    []*common.Hash{nil, &other},
+			canIgnore: true,
+		},
+		{
+			name:      "transfer-with-extra-topic",
+			topics:    []*common.Hash{nil, nil, &other},
+			canIgnore: true,
+		},
+		{
+			name:      "assertion-failed",
+			assertion: true,
+			canIgnore: true,
+		},
+	}
+	for _, tc := range eventTests {
+		a := newTestAnalyzer(t, Config{})
+		if tc.assertion {
+			a.TargetAssertionFailed()
+		} else {
+			a.AddTargetEventSignature(transfer, tc.topics...)
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore {
+			t.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.name, tc.canIgnore, canIgnore, cause)
+			continue
+		}
+		if cause != tc.failureCause {
+			t.Errorf("[%v] expected failure cause '%v', but got '%v'", tc.name, tc.failureCause, cause)
+		}
+	}
+}
+
+func TestTargets(t *testing.T) {
+	// Stores calldata in slot 1, then 5 in slot 0, and finally self-destructs.
+	code, _ := hex.DecodeString("600035600155600560005533ff")
+	targetTests := []struct {
+		spec         string
+		canIgnore    bool
+		failureCause string
+	}{
+		{
+			spec:         "SELFDESTRUCT",
+			canIgnore:    false,
+			failureCause: "reached-target(SELFDESTRUCT)",
+		},
+		{
+			spec:      "DELEGATECALL",
+			canIgnore: true,
+		},
+		{
+			spec:         "sstore=0",
+			canIgnore:    false,
+			failureCause: "reached-target(sstore=0)",
+		},
+		{
+			spec:      "sstore=2",
+			canIgnore: true,
+		},
+		{
+			spec:         "pc=0x5 stack[0]==1",
+			canIgnore:    false,
+			failureCause: "reached-target(pc=0x5 stack[0]==1)",
+		},
+		{
+			spec:      "pc=0x5 stack[0]==2",
+			canIgnore: true,
+		},
+		{
+			spec:         "pc=0x5 stack[1]==7",
+			canIgnore:    false,
+			failureCause: "reached-target(pc=0x5 stack[1]==7)",
+		},
+		{
+			spec:      "nonconst-call",
+			canIgnore: true,
+		},
+	}
+	for _, tc := range targetTests {
+		a := newTestAnalyzer(t, Config{})
+		if err := a.AddTargetSpec(tc.spec); err != nil {
+			t.Errorf("[%v] unexpected invalid target: %v", tc.spec, err)
+			continue
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.spec, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore {
+			t.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.spec, tc.canIgnore, canIgnore, cause)
+			continue
+		}
+		if cause != tc.failureCause {
+			t.Errorf("[%v] expected failure cause '%v', but got '%v'", tc.spec, tc.failureCause, cause)
+		}
+	}
+
+	// PCs have the same format with and without a code hash.
+	codeHash := crypto.Keccak256Hash(code)
+	for _, spec := range []string{"pc=10", "pc=0xa", fmt.Sprintf("pc=%x:10", codeHash), fmt.Sprintf("pc=0x%x:0xa", codeHash)} {
+		target, err := ParseTarget(spec)
+		if err != nil {
+			t.Errorf("[%v] unexpected invalid target: %v", spec, err)
+			continue
+		}
+		if target.PC == nil || *target.PC != 10 {
+			t.Errorf("[%v] expected PC 10, but got %v", spec, target.PC)
+		}
+		if strings.Contains(spec, ":") && (target.CodeHash == nil || *target.CodeHash != codeHash) {
+			t.Errorf("[%v] expected code hash %x, but got %v", spec, codeHash, target.CodeHash)
+		}
+	}
+	for _, target := range []Target{LocationTarget(nil, 10), LocationTarget(&codeHash, 10)} {
+		parsed, err := ParseTarget(target.Name)
+		if err != nil || parsed.PC == nil || *parsed.PC != 10 || (parsed.CodeHash == nil) != (target.CodeHash == nil) {
+			t.Errorf("[%v] expected name to parse to the same location (error: %v)", target.Name, err)
+		}
+	}
+
+	invalidSpecs := []string{
+		"", "NOSUCHOP", "CALL stack[x]==0", "sstore=abc",
+		// Code hashes need exactly 32 bytes of valid hex.
+		fmt.Sprintf("pc=%x:10", codeHash[:31]),
+		fmt.Sprintf("pc=%x00:10", codeHash),
+		fmt.Sprintf("pc=%xzz:10", codeHash[:31]),
+		fmt.Sprintf("pc=%x0:10", codeHash[:31]),
+	}
+	for _, spec := range invalidSpecs {
+		if _, err := ParseTarget(spec); err == nil {
+			t.Errorf("[%v] expected invalid target to be rejected", spec)
+		}
+	}
+}
+
+func TestCreation(t *testing.T) {
+	runtime := "7f" + strings.Repeat("00", 32) + "60655000"
+	withImmutable := "7f" + strings.Repeat("00", 31) + "2a" + "60655000"
+	creationTests := []struct {
+		constructor   string
+		mayFail       bool
+		runtime       string
+		unknownOffset int
+		numUnknown    int
+	}{
+		{
+			// The constructor stores the constant 0x2a in the immutable.
+			constructor: "6025601160003960" + "2a" + "600152602560" + "00f3",
+			runtime:     withImmutable,
+		},
+		{
+			// The constructor stores the caller in the immutable.
+			constructor:   "602560116000393" + "35b" + "600152602560" + "00f3",
+			runtime:       runtime,
+			unknownOffset: 1,
+			numUnknown:    32,
+		},
+		{
+			// The constructor fails if it receives a value.
+			constructor: "3415600657" + "fe" + "5b00",
+			mayFail:     true,
+		},
+	}
+	for _, tc := range creationTests {
+		code, _ := hex.DecodeString(tc.constructor + runtime)
+		a := newTestAnalyzer(t, Config{})
+		res, err := a.AnalyzeCreation(code, crypto.Keccak256Hash(code).Bytes(), nil)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.constructor, err)
+			continue
+		}
+		if res.MayFail != tc.mayFail {
+			t.Errorf("[%v] expected failure %v, but got %v (failure cause '%v')", tc.constructor, tc.mayFail, res.MayFail, res.FailureCause)
+			continue
+		}
+		if tc.mayFail {
+			if res.FailureCause != InvalidOpcodeFail {
+				t.Errorf("[%v] expected failure cause '%v', but got '%v'", tc.constructor, InvalidOpcodeFail, res.FailureCause)
+			}
+			continue
+		}
+		if hex.EncodeToString(res.RuntimeCode) != tc.runtime {
+			t.Errorf("[%v] expected runtime code %v, but got %x", tc.constructor, tc.runtime, res.RuntimeCode)
+		}
+		if len(res.UnknownRuntimeBytes) != tc.numUnknown {
+			t.Errorf("[%v] expected %v unknown bytes, but got %v", tc.constructor, tc.numUnknown, res.UnknownRuntimeBytes)
+		} else if 0 < tc.numUnknown && res.UnknownRuntimeBytes[0] != tc.unknownOffset {
+			t.Errorf("[%v] expected unknown bytes at offset %v, but got %v", tc.constructor, tc.unknownOffset, res.UnknownRuntimeBytes)
+		}
+	}
+}
+
+func TestCodeLayout(t *testing.T) {
+	for _, tc := range tests {
+		if !strings.HasSuffix(tc.code, "0029") {
+			// Some of the test contracts have a truncated metadata trailer.
+			continue
+		}
+		code, _ := hex.DecodeString(tc.code)
+		layout := AnalyzeCodeLayout(code)
+		if layout.Metadata == nil {
+			t.Errorf("[%v] expected metadata trailer", tc.name)
+			continue
+		}
+		if len(layout.Metadata.SwarmHash) != 32 || layout.CompilerVersion() != "" {
+			t.Errorf("[%v] unexpected metadata %+v", tc.name, layout.Metadata)
+		}
+		if offset := uint64(strings.LastIndex(tc.code, "a165627a7a72305820") / 2); layout.Metadata.Offset != offset {
+			t.Errorf("[%v] expected metadata at offset %v, but got %v", tc.name, offset, layout.Metadata.Offset)
+		}
+		if layout.IsData(0) || !layout.IsData(uint64(len(code)-1)) {
+			t.Errorf("[%v] expected executable code at the beginning and data at the end", tc.name)
+		}
+	}
+
+	// The code jumps to a JUMPDEST in the IPFS hash of the metadata, which is followed by an INVALID.
+	ipfsHash := "5bfe" + strings.Repeat("11", 32)
+	code, _ := hex.DecodeString("600c56fe" + "a2646970667358" + "22" + ipfsHash + "64736f6c6343000813" + "0033")
+	a := newTestAnalyzer(t, Config{})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	layout := a.CodeLayout(1)
+	if v := layout.CompilerVersion(); v != "0.8.19" {
+		t.Errorf("expected compiler version 0.8.19, but got '%v'", v)
+	}
+	if hex.EncodeToString(layout.Metadata.IPFSHash) != ipfsHash {
+		t.Errorf("expected IPFS hash %v, but got %x", ipfsHash, layout.Metadata.IPFSHash)
+	}
+	if !layout.IsData(3) || layout.IsData(2) {
+		t.Errorf("expected the INVALID after the jump to be unreachable")
+	}
+	canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+	if err != nil {
+		t.Errorf("analysis ended with an error: %v", err)
+	} else if !canIgnore {
+		t.Errorf("expected data to be ignored, but got failure cause '%v'", cause)
+	}
+}
+
+func TestForks(t *testing.T) {
+	// The code stores 1 in transient slot 0, loads it again, copies a word in memory with MCOPY and only reaches
+	// invalid instructions if any of these operations are imprecise.
+	code, _ := hex.DecodeString("60015f5d5f5c600a57fe5b602a5f526020" + "5f60205e602051602a1460" + "1f57fe5b00")
+	forkTests := []struct {
+		fork         Fork
+		canIgnore    bool
+		failureCause string
+	}{
+		{
+			fork:         ForkIstanbul,
+			failureCause: InvalidOpcodeFail,
+		},
+		{
+			fork:         ForkShanghai,
+			failureCause: InvalidOpcodeFail,
+		},
+		{
+			fork:      ForkCancun,
+			canIgnore: true,
+		},
+	}
+	for _, tc := range forkTests {
+		a := newTestAnalyzer(t, Config{Fork: tc.fork})
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.fork, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore || cause != tc.failureCause {
+			t.Errorf("[%v] expected analysis result %v (failure cause '%v'), but got %v (failure cause '%v')", tc.fork, tc.canIgnore, tc.failureCause, canIgnore, cause)
+		}
+	}
+
+	if f, err := ParseFork("Cancun"); err != nil || f != ForkCancun {
+		t.Errorf("expected to parse fork 'Cancun', but got %v (error: %v)", f, err)
+	}
+	if _, err := ParseFork("frontier"); err == nil {
+		t.Errorf("expected unsupported fork to be rejected")
+	}
+}
+
+func TestTransientStorage(t *testing.T) {
+	// The code reverts if the lock in transient slot 0 is held. Otherwise, it acquires the lock, calls the caller,
+	// writes to storage and releases the lock.
+	code, _ := hex.DecodeString("5f5c601a5760015f5d5f5f5f5f5f335af15060015f555f5f5d005b5f5ffd")
+	codeHash := crypto.Keccak256Hash(code).Bytes()
+	outerPrefix := []uint64{0, 1, 2, 4, 5, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+
+	a := newTestAnalyzer(t, Config{Fork: ForkCancun})
+	if err := a.AddTargetSpec("SSTORE"); err != nil {
+		t.Fatalf("unexpected invalid target: %v", err)
+	}
+	a.Start(0, code, codeHash)
+	for _, pc := range outerPrefix {
+		a.AppendPrefixInstruction(0, pc)
+	}
+
+	// The reentrant call starts while the outer call holds the lock.
+	if err := a.StartNestedCall(1, 0, code, codeHash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.AppendPrefixInstruction(1, 0)
+	canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+	if err != nil || !canIgnore {
+		t.Errorf("expected locked reentrant call to be ignored, but got failure cause '%v' (error: %v)", cause, err)
+	}
+
+	// A second reentrant call during the same pending call may observe changes made by the first one.
+	if err := a.StartNestedCall(2, 0, code, codeHash); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a.AppendPrefixInstruction(2, 0)
+	if canIgnore, _, _, _, _ := a.CanIgnoreSuffix(2); canIgnore {
+		t.Errorf("expected second reentrant call not to be ignored")
+	}
+
+	// A new transaction starts with empty transient storage.
+	a.Start(3, code, codeHash)
+	a.AppendPrefixInstruction(3, 0)
+	if canIgnore, _, _, _, _ := a.CanIgnoreSuffix(3); canIgnore {
+		t.Errorf("expected unlocked call not to be ignored")
+	}
+
+	if err := a.StartNestedCall(4, 42, code, codeHash); err == nil {
+		t.Errorf("expected nested call without parent to be rejected")
+	}
+}
+
+func TestOpcodeExtensions(t *testing.T) {
+	// The code doubles 21 with the custom opcode 0x0c and reaches an invalid instruction unless the result is 42.
+	code, _ := hex.DecodeString("60150c602a14600a57fe5b00")
+	double := OpcodeExtension{
+		Opcode: vm.OpCode(0x0c),
+		Concrete: vm.OperationSpec{
+			Execute: func(pc *uint64, interpreter *vm.EVMInterpreter, contract *vm.Contract, memory *vm.Memory, stack *vm.Stack) ([]byte, error) {
+				x := stack.Peek()
+				x.Lsh(x, 1)
+				return nil, nil
+			},
+			ConstantGas: 3,
+			Pops:        1,
+			Pushes:      1,
+		},
+		Abstract: ConcreteStackOp(1, 1),
+	}
+	extensionTests := []struct {
+		extensions   []OpcodeExtension
+		canIgnore    bool
+		failureCause string
+	}{
+		{
+			failureCause: InvalidOpcodeFail,
+		},
+		{
+			extensions: []OpcodeExtension{double},
+			canIgnore:  true,
+		},
+		{
+			extensions: []OpcodeExtension{
+				{
+					Opcode:   double.Opcode,
+					Concrete: double.Concrete,
+					Abstract: PopPushTopOp(1, 1),
+				},
+			},
+			failureCause: InvalidOpcodeFail,
+		},
+	}
+	for i, tc := range extensionTests {
+		a := newTestAnalyzer(t, Config{})
+		for _, ext := range tc.extensions {
+			if err := a.AddOpcodeExtension(ext); err != nil {
+				t.Fatalf("[%v] unexpected invalid extension: %v", i, err)
+			}
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", i, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore || cause != tc.failureCause {
+			t.Errorf("[%v] expected analysis result %v (failure cause '%v'), but got %v (failure cause '%v')", i, tc.canIgnore, tc.failureCause, canIgnore, cause)
+		}
+	}
+
+	redefining := double
+	redefining.Opcode = vm.ADD
+	mismatchedStack := double
+	mismatchedStack.Abstract = ConcreteStackOp(2, 1)
+	mismatchedMem := double
+	mismatchedMem.Abstract = PopPushMemTopOp(1, 1, 0, 0)
+	invalidExtensions := []struct {
+		name string
+		ext  OpcodeExtension
+	}{
+		{name: "redefining-add", ext: redefining},
+		{name: "mismatched-stack", ext: mismatchedStack},
+		{name: "mismatched-memory", ext: mismatchedMem},
+	}
+	for _, tc := range invalidExtensions {
+		if err := newTestAnalyzer(t, Config{}).AddOpcodeExtension(tc.ext); err == nil {
+			t.Errorf("[%v] expected invalid extension to be rejected", tc.name)
+		}
+	}
+	// Halting semantics are compatible with any concrete semantics.
+	halting := double
+	halting.Abstract = HaltingOp()
+	if err := newTestAnalyzer(t, Config{}).AddOpcodeExtension(halting); err != nil {
+		t.Errorf("unexpected invalid halting extension: %v", err)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	// The code stores a word at an offset from the calldata.
+	code, _ := hex.DecodeString("60006000355200")
+	configTests := []struct {
+		cfg          Config
+		canIgnore    bool
+		failureCause string
+	}{
+		{
+			cfg:       Config{},
+			canIgnore: true,
+		},
+		{
+			cfg:          Config{FailOnTopMemResize: true},
+			failureCause: TopMemoryResizeFail,
+		},
+	}
+	for i, tc := range configTests {
+		a := newTestAnalyzer(t, tc.cfg)
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", i, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore || cause != tc.failureCause {
+			t.Errorf("[%v] expected analysis result %v (failure cause '%v'), but got %v (failure cause '%v')", i, tc.canIgnore, tc.failureCause, canIgnore, cause)
+		}
+	}
+
+	a := newTestAnalyzer(t, Config{MaxPrefixLen: 1})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	a.AppendPrefixInstruction(1, 2)
+	if _, _, _, _, err := a.CanIgnoreSuffix(1); err == nil {
+		t.Errorf("expected prefix that exceeds the maximum length to be rejected")
+	}
+
+	if cfg := newTestAnalyzer(t, Config{}).Config(); cfg.hash() != DefaultConfig().hash() {
+		t.Errorf("expected zero values to be replaced by defaults, but got %+v", cfg)
+	}
+	if DefaultConfig().hash() == (Config{UseBoundedJoins: true}).withDefaults().hash() {
+		t.Errorf("expected different configurations to have different cache keys")
+	}
+
+	ext := OpcodeExtension{
+		Opcode: 0xef,
+		Concrete: vm.OperationSpec{
+			Execute: func(pc *uint64, interpreter *vm.EVMInterpreter, contract *vm.Contract, memory *vm.Memory, stack *vm.Stack) ([]byte, error) {
+				return nil, nil
+			},
+		},
+		Abstract: ConcreteStackOp(0, 0),
+	}
+	// Extensions with the same stack bounds but different semantics must not share cached results.
+	topExt, newExt, clobberingExt := ext, ext, ext
+	topExt.Abstract = PopPushTopOp(0, 0)
+	newExt.Version = "v2"
+	clobberingExt.Abstract = ext.Abstract.ClobberingStorage()
+	digests := map[[sha256.Size]byte]bool{}
+	for _, e := range []OpcodeExtension{ext, topExt, newExt, clobberingExt} {
+		digests[Config{Extensions: []OpcodeExtension{e}}.withDefaults().hash()] = true
+	}
+	if len(digests) != 4 {
+		t.Errorf("expected extensions with different semantics to have different cache keys")
+	}
+	invalidConfigs := []Config{
+		{MaxPrefixLen: -1},
+		{BackpropSteps: -1},
+		{Fork: Fork(42)},
+		{Extensions: []OpcodeExtension{ext, ext}},
+	}
+	for i, cfg := range invalidConfigs {
+		if _, err := NewLookaheadAnalyzer(cfg); err == nil {
+			t.Errorf("[%v] expected invalid configuration to be rejected", i)
+		}
+	}
+}
+
+func TestCacheKeys(t *testing.T) {
+	// Results for the same prefix in different contracts must not be mixed up.
+	a := newTestAnalyzer(t, Config{})
+	for _, tc := range tests {
+		code, err := hex.DecodeString(tc.code)
+		if err != nil {
+			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
+			continue
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		for _, pc := range tc.prefix {
+			a.AppendPrefixInstruction(1, pc)
+		}
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+		}
+		if canIgnore != tc.canIgnore {
+			t.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.name, tc.canIgnore, canIgnore, cause)
+		}
+	}
+
+	// Cached results must be invalidated when targets are added.
+	code, _ := hex.DecodeString("600160010100")
+	codeHash := crypto.Keccak256Hash(code).Bytes()
+	a = newTestAnalyzer(t, Config{})
+	analyze := func() (bool, string) {
+		a.Start(1, code, codeHash)
+		a.AppendPrefixInstruction(1, 0)
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil {
+			t.Fatalf("analysis ended with an error: %v", err)
+		}
+		return canIgnore, cause
+	}
+	if canIgnore, cause := analyze(); !canIgnore {
+		t.Errorf("expected suffix to be ignored, but got failure cause '%v'", cause)
+	}
+	a.AddTargetInstruction(codeHash, 4)
+	if canIgnore, cause := analyze(); canIgnore || cause != ReachedTargetInstructionFail {
+		t.Errorf("expected failure cause '%v' after adding a target, but got %v (failure cause '%v')", ReachedTargetInstructionFail, canIgnore, cause)
+	}
+}
+
+func TestResultCache(t *testing.T) {
+	// The keys are in the same shard, which has room for two results.
+	entrySize := (&cacheEntry{}).size()
+	c := newResultCache(2 * entrySize * numCacheShards)
+	var k1, k2, k3 cacheKey
+	k1[31], k2[31], k3[31] = 1, 2, 3
+	c.put(k1, result{})
+	c.put(k2, result{})
+	if _, found := c.get(k1); !found {
+		t.Errorf("expected result to be cached")
+	}
+	c.put(k3, result{})
+	if _, found := c.get(k2); found {
+		t.Errorf("expected least recently used result to be evicted")
+	}
+	if _, found := c.get(k1); !found {
+		t.Errorf("expected recently used result to be cached")
+	}
+	if c.hits.Load() != 2 || c.misses.Load() != 1 || c.evictions.Load() != 1 {
+		t.Errorf("expected 2 hits, 1 miss and 1 eviction, but got %v, %v and %v", c.hits.Load(), c.misses.Load(), c.evictions.Load())
+	}
+	if num, size := c.sizes(); num != 2 || size != 2*entrySize {
+		t.Errorf("expected 2 results with %v bytes, but got %v results with %v bytes", 2*entrySize, num, size)
+	}
+
+	code, _ := hex.DecodeString("600160010100")
+	a := newTestAnalyzer(t, Config{})
+	for i := 0; i < 3; i++ {
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		if _, _, _, _, err := a.CanIgnoreSuffix(1); err != nil {
+			t.Fatalf("analysis ended with an error: %v", err)
+		}
+	}
+	if a.CacheHits() != 2 || a.CacheMisses() != 1 || a.CacheEvictions() != 0 {
+		t.Errorf("expected 2 hits and 1 miss, but got %v hits, %v misses and %v evictions", a.CacheHits(), a.CacheMisses(), a.CacheEvictions())
+	}
+}
+
+func TestSaveCache(t *testing.T) {
+	code, _ := hex.DecodeString("600160010100")
+	codeHash := crypto.Keccak256Hash(code).Bytes()
+	analyze := func(a *LookaheadAnalyzer) {
+		a.Start(1, code, codeHash)
+		a.AppendPrefixInstruction(1, 0)
+		if _, _, _, _, err := a.CanIgnoreSuffix(1); err != nil {
+			t.Fatalf("analysis ended with an error: %v", err)
+		}
+	}
+	newAnalyzer := func() *LookaheadAnalyzer {
+		a := newTestAnalyzer(t, Config{})
+		a.TargetPanics(0x01)
+		return a
+	}
+
+	a := newAnalyzer()
+	analyze(a)
+	var buf bytes.Buffer
+	if err := a.SaveCache(&buf); err != nil {
+		t.Fatalf("unexpected error when saving cache: %v", err)
+	}
+	saved := buf.Bytes()
+
+	a = newAnalyzer()
+	if n, err := a.LoadCache(bytes.NewReader(saved)); err != nil || n != 1 {
+		t.Errorf("expected 1 loaded result, but got %v (error: %v)", n, err)
+	}
+	analyze(a)
+	if a.CacheHits() != 1 || a.CacheMisses() != 0 {
+		t.Errorf("expected loaded result to be used, but got %v hits and %v misses", a.CacheHits(), a.CacheMisses())
+	}
+
+	a = newAnalyzer()
+	a.TargetPanics()
+	if _, err := a.LoadCache(bytes.NewReader(saved)); err == nil {
+		t.Errorf("expected cache with different targets to be rejected")
+	}
+	a = newTestAnalyzer(t, Config{MaxDisjuncts: 1})
+	a.TargetPanics(0x01)
+	if _, err := a.LoadCache(bytes.NewReader(saved)); err == nil {
+		t.Errorf("expected cache with different configuration to be rejected")
+	}
+	// Results that exceeded the budget are only cached under CacheBudgetExceeded.
+	a = newTestAnalyzer(t, Config{BudgetPolicy: CacheBudgetExceeded})
+	a.TargetPanics(0x01)
+	if _, err := a.LoadCache(bytes.NewReader(saved)); err == nil {
+		t.Errorf("expected cache with different budget policy to be rejected")
+	}
+	if _, err := newAnalyzer().LoadCache(bytes.NewReader(saved[:len(saved)-1])); err == nil {
+		t.Errorf("expected truncated cache to be rejected")
+	}
+
+	// Failure causes may be longer than 64 KiB.
+	newLongAnalyzer := func() *LookaheadAnalyzer {
+		a := newTestAnalyzer(t, Config{})
+		target := OpcodeTarget(vm.ADD)
+		target.Name = strings.Repeat("x", 70000)
+		a.AddTarget(target)
+		return a
+	}
+	a = newLongAnalyzer()
+	analyze(a)
+	buf.Reset()
+	if err := a.SaveCache(&buf); err != nil {
+		t.Fatalf("unexpected error when saving cache: %v", err)
+	}
+	a = newLongAnalyzer()
+	if n, err := a.LoadCache(bytes.NewReader(buf.Bytes())); err != nil || n != 1 {
+		t.Errorf("expected 1 loaded result with a long failure cause, but got %v (error: %v)", n, err)
+	}
+	a.Start(1, code, codeHash)
+	a.AppendPrefixInstruction(1, 0)
+	if _, _, cause, _, _ := a.CanIgnoreSuffix(1); cause != fmt.Sprintf("%v(%v)", ReachedTargetFail, strings.Repeat("x", 70000)) || a.CacheHits() != 1 {
+		t.Errorf("expected loaded long failure cause, but got one of length %v", len(cause))
+	}
+}
+
+func TestCoveredAssertionCache(t *testing.T) {
+	// The first contract cannot fail and the second one reaches the assertion at PC 2.
+	safe, _ := hex.DecodeString("600160010100")
+	failing, _ := hex.DecodeString("6000fe")
+	analyze := func(a *LookaheadAnalyzer) {
+		for _, code := range [][]byte{safe, failing} {
+			a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+			a.AppendPrefixInstruction(1, 0)
+			if _, _, _, _, err := a.CanIgnoreSuffix(1); err != nil {
+				t.Fatalf("analysis ended with an error: %v", err)
+			}
+		}
+	}
+	save := func(a *LookaheadAnalyzer) []byte {
+		var buf bytes.Buffer
+		if err := a.SaveCache(&buf); err != nil {
+			t.Fatalf("unexpected error when saving cache: %v", err)
+		}
+		return buf.Bytes()
+	}
+	other := crypto.Keccak256Hash([]byte("other")).Bytes()
+
+	a := newTestAnalyzer(t, Config{})
+	analyze(a)
+	saved := save(a)
+	// Covering an assertion only invalidates the result with a possible failure.
+	a.RecordCoveredAssertion(other, 0)
+	if num, _ := a.CacheSize(); num != 1 {
+		t.Errorf("expected 1 cached result after covering an assertion, but got %v", num)
+	}
+	analyze(a)
+	savedCovered := save(a)
+
+	loadTests := []struct {
+		name    string
+		saved   []byte
+		covered bool
+		num     int
+	}{
+		{name: "same-coverage", saved: saved, num: 2},
+		{name: "new-coverage", saved: saved, covered: true, num: 1},
+		{name: "lost-coverage", saved: savedCovered, num: 1},
+		{name: "same-covered", saved: savedCovered, covered: true, num: 2},
+	}
+	for _, tc := range loadTests {
+		b := newTestAnalyzer(t, Config{})
+		if tc.covered {
+			b.RecordCoveredAssertion(other, 0)
+		}
+		if n, err := b.LoadCache(bytes.NewReader(tc.saved)); err != nil || n != tc.num {
+			t.Errorf("[%v] expected %v loaded results, but got %v (error: %v)", tc.name, tc.num, n, err)
+		}
+	}
+
+	// Corrupted lengths and counts are rejected before allocating memory for them.
+	headerEnd := len(cacheMagic) + binary.Size(cacheHeader{})
+	numCoveredOffset := headerEnd - 16
+	corruptions := []struct {
+		name   string
+		offset int
+		value  []byte
+	}{
+		{name: "huge-loc-len", offset: headerEnd, value: []byte{0xff, 0xff, 0xff, 0xff}},
+		{name: "huge-num-covered", offset: numCoveredOffset, value: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{name: "huge-num-entries", offset: numCoveredOffset + 8, value: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+	}
+	for _, tc := range corruptions {
+		corrupted := append([]byte{}, savedCovered...)
+		copy(corrupted[tc.offset:], tc.value)
+		b := newTestAnalyzer(t, Config{})
+		b.RecordCoveredAssertion(other, 0)
+		if _, err := b.LoadCache(bytes.NewReader(corrupted)); err == nil {
+			t.Errorf("[%v] expected corrupted cache to be rejected", tc.name)
+		}
+	}
+}
+
+func TestPrefixCheckpoints(t *testing.T) {
+	for _, tc := range tests {
+		code, err := hex.DecodeString(tc.code)
+		if err != nil {
+			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
+			continue
+		}
+		codeHash := crypto.Keccak256Hash(code).Bytes()
+		// The analysis of growing prefixes resumes from checkpoints, which must not change the results.
+		a := newTestAnalyzer(t, Config{})
+		a.Start(1, code, codeHash)
+		for i, pc := range tc.prefix {
+			a.AppendPrefixInstruction(1, pc)
+			if i%8 != 0 && i != len(tc.prefix)-1 {
+				continue
+			}
+			canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+
+			fresh := newTestAnalyzer(t, Config{})
+			fresh.Start(1, code, codeHash)
+			for _, prefixPC := range tc.prefix[:i+1] {
+				fresh.AppendPrefixInstruction(1, prefixPC)
+			}
+			expCanIgnore, _, expCause, _, expErr := fresh.CanIgnoreSuffix(1)
+			if canIgnore != expCanIgnore || cause != expCause || (err == nil) != (expErr == nil) {
+				t.Errorf("[%v] expected analysis result %v (failure cause '%v', error: %v) for prefix of length %v, but got %v (failure cause '%v', error: %v)", tc.name, expCanIgnore, expCause, expErr, i+1, canIgnore, cause, err)
+			}
+		}
+
+		if !tc.canIgnore || len(tc.prefix) == 0 {
+			continue
+		}
+		info := a.session.callInfos[1]
+		if _, n, _ := a.session.checkpoints.root(checkpointKey(1, info)).lookup(info.prefix); n != len(tc.prefix) {
+			t.Errorf("[%v] expected checkpoint after %v instructions, but got %v", tc.name, len(tc.prefix), n)
+		}
+	}
+}
+
+func TestSuffixCache(t *testing.T) {
+	// Both branches of the JUMPI reach the JUMPDEST at PC 10 with the same state.
+	code, _ := hex.DecodeString("600035600957600a565b5b00")
+	codeHash := crypto.Keccak256Hash(code).Bytes()
+	a := newTestAnalyzer(t, Config{})
+	for _, prefix := range [][]uint64{{0, 2, 3, 5, 6, 8, 10}, {0, 2, 3, 5, 9, 10}} {
+		a.Start(1, code, codeHash)
+		for _, pc := range prefix {
+			a.AppendPrefixInstruction(1, pc)
+		}
+		if canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1); err != nil || !canIgnore {
+			t.Errorf("expected suffix to be ignored, but got failure cause '%v' (error: %v)", cause, err)
+		}
+	}
+	if a.SuffixCacheHits() != 1 || a.SuffixCacheMisses() != 1 || a.SuffixCacheHitRate() != 0.5 {
+		t.Errorf("expected suffix analysis to be shared, but got %v hits and %v misses", a.SuffixCacheHits(), a.SuffixCacheMisses())
+	}
+
+	// The entry states differ in the stack.
+	a.Start(1, code, codeHash)
+	for _, pc := range []uint64{0, 2} {
+		a.AppendPrefixInstruction(1, pc)
+	}
+	a.CanIgnoreSuffix(1)
+	if a.SuffixCacheMisses() != 2 {
+		t.Errorf("expected suffix analysis for different entry states, but got %v misses", a.SuffixCacheMisses())
+	}
+}
+
+func TestBudget(t *testing.T) {
+	code, _ := hex.DecodeString("600035600957600a565b5b00")
+	codeHash := crypto.Keccak256Hash(code).Bytes()
+	budgetTests := []struct {
+		name           string
+		cfg            Config
+		cancel         bool
+		canIgnore      bool
+		avoidRetry     bool
+		expectedCached int
+	}{
+		{name: "unlimited", cfg: Config{}, canIgnore: true, expectedCached: 1},
+		{name: "iterations", cfg: Config{MaxIterations: 2}},
+		{name: "states", cfg: Config{MaxStates: 1}},
+		{name: "timeout", cfg: Config{Timeout: time.Nanosecond}},
+		{name: "cached", cfg: Config{MaxIterations: 2, BudgetPolicy: CacheBudgetExceeded}, avoidRetry: true, expectedCached: 1},
+		{name: "cancelled", cfg: Config{}, cancel: true},
+	}
+	for _, tc := range budgetTests {
+		a := newTestAnalyzer(t, tc.cfg)
+		a.Start(1, code, codeHash)
+		a.AppendPrefixInstruction(1, 0)
+		ctx, cancel := context.WithCancel(context.Background())
+		if tc.cancel {
+			cancel()
+		}
+		canIgnore, avoidRetry, cause, _, err := a.CanIgnoreSuffixContext(ctx, 1)
+		cancel()
+		if tc.cancel {
+			if err != context.Canceled {
+				t.Errorf("[%v] expected cancellation, but got error %v", tc.name, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if canIgnore != tc.canIgnore || avoidRetry != tc.avoidRetry {
+			t.Errorf("[%v] expected (%v, %v), but got (%v, %v)", tc.name, tc.canIgnore, tc.avoidRetry, canIgnore, avoidRetry)
+		}
+		if !tc.canIgnore && cause != BudgetExceededFail {
+			t.Errorf("[%v] expected failure cause '%v', but got '%v'", tc.name, BudgetExceededFail, cause)
+		}
+		if num, _ := a.CacheSize(); num != tc.expectedCached {
+			t.Errorf("[%v] expected %v cached results, but got %v", tc.name, tc.expectedCached, num)
+		}
+	}
+}
+
+func TestResult(t *testing.T) {
+	resultTests := []struct {
+		name          string
+		code          string
+		prefix        []uint64
+		verdict       Verdict
+		cause         FailureCause
+		failurePC     uint64
+		location      Location
+		justification string
+	}{
+		{
+			name:          "suffix",
+			code:          "600035600757fe5b00",
+			prefix:        []uint64{0},
+			verdict:       VerdictMayFail,
+			cause:         FailureCause{Kind: CauseInvalidOpcode},
+			failurePC:     6,
+			location:      LocationSuffix,
+			justification: InvalidOpcodeFail,
+		},
+		{
+			name:          "prefix",
+			code:          "600035565b00",
+			prefix:        []uint64{0, 2, 3},
+			verdict:       VerdictMayFail,
+			cause:         FailureCause{Kind: CauseJumpToTop},
+			failurePC:     3,
+			location:      LocationPrefix,
+			justification: "prefix-computation-failure(jump-to-top)",
+		},
+		{
+			name:    "ignorable",
+			code:    "600035600757fe5b00",
+			prefix:  []uint64{0, 2, 3, 5, 7},
+			verdict: VerdictCanIgnore,
+		},
+	}
+	for _, tc := range resultTests {
+		code, _ := hex.DecodeString(tc.code)
+		a := newTestAnalyzer(t, Config{})
+		for _, expectedCache := range []CacheStatus{CacheMiss, CacheHit} {
+			a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+			for _, pc := range tc.prefix {
+				a.AppendPrefixInstruction(1, pc)
+			}
+			res, err := a.AnalyzeSuffix(context.Background(), 1)
+			if err != nil {
+				t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+				continue
+			}
+			if res.Verdict != tc.verdict || res.Cause != tc.cause || res.Location != tc.location || res.Cache != expectedCache {
+				t.Errorf("[%v] unexpected result %+v", tc.name, res)
+			}
+			if tc.verdict == VerdictMayFail && (!res.HasFailurePC || res.FailurePC != tc.failurePC) {
+				t.Errorf("[%v] expected failure at PC %v, but got %+v", tc.name, tc.failurePC, res)
+			}
+			if expectedCache == CacheMiss && res.NumStates == 0 && tc.location != LocationPrefix {
+				t.Errorf("[%v] expected explored states", tc.name)
+			}
+			canIgnore, _, justification, _, _ := a.CanIgnoreSuffix(1)
+			if canIgnore != (tc.verdict == VerdictCanIgnore) || justification != tc.justification || res.Justification() != justification {
+				t.Errorf("[%v] expected justification '%v', but got '%v' and '%v'", tc.name, tc.justification, justification, res.Justification())
+			}
+		}
+	}
+
+	for _, cause := range []string{"reached-target(withdraw)", "reached-panic(0x1)", InvalidOpcodeFail, "unknown-cause(x)"} {
+		if parsed := parseFailureCause(cause); parsed.String() != cause {
+			t.Errorf("expected cause '%v' to be preserved, but got '%v'", cause, parsed)
+		}
+	}
+}
+
+func TestWitness(t *testing.T) {
+	// The INVALID at PC 8 is only reached if the JUMPI at PC 5 jumps.
+	code, _ := hex.DecodeString("600035600757005bfe")
+	a := newTestAnalyzer(t, Config{})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	res, err := a.AnalyzeSuffix(context.Background(), 1)
+	if err != nil || res.Verdict != VerdictMayFail {
+		t.Fatalf("expected possible failure, but got %+v (error: %v)", res, err)
+	}
+	if res.Witness == nil {
+		t.Fatalf("expected witness")
+	}
+	if expected := []uint64{2, 3, 5, 7, 8}; fmt.Sprint(res.Witness.PCs) != fmt.Sprint(expected) {
+		t.Errorf("expected path %v, but got %v", expected, res.Witness.PCs)
+	}
+	if len(res.Witness.Branches) != 1 {
+		t.Fatalf("expected one branch, but got %v", len(res.Witness.Branches))
+	}
+	branch := res.Witness.Branches[0]
+	if branch.PC != 5 || len(branch.Stack) != 2 || branch.Stack[0].Uint64() != 7 || branch.Stack[1] != nil {
+		t.Errorf("expected known jump destination and unknown condition, but got %+v", branch)
+	}
+
+	// Cached results have no witness.
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	if res, _ := a.AnalyzeSuffix(context.Background(), 1); res.Cache != CacheHit || res.Witness != nil {
+		t.Errorf("expected cached result without witness, but got %+v", res)
+	}
+}
+
+func TestFailureSites(t *testing.T) {
+	siteTests := []struct {
+		name     string
+		code     string
+		expected map[uint64]bool
+	}{
+		{
+			// The condition of the JUMPI at PC 4 is known.
+			name:     "definite",
+			code:     "6001600757fe005bfe",
+			expected: map[uint64]bool{8: true},
+		},
+		{
+			// The condition of the JUMPI at PC 5 is unknown.
+			name:     "possible",
+			code:     "600035600a57fe0000005bfe",
+			expected: map[uint64]bool{6: false, 11: false},
+		},
+	}
+	for _, tc := range siteTests {
+		code, _ := hex.DecodeString(tc.code)
+		a := newTestAnalyzer(t, Config{})
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		sites, err := a.FailureSites(context.Background(), 1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if len(sites) != len(tc.expected) {
+			t.Errorf("[%v] expected %v sites, but got %+v", tc.name, len(tc.expected), sites)
+			continue
+		}
+		for _, site := range sites {
+			definite, found := tc.expected[site.PC]
+			if !found || site.Definite != definite || site.Cause.Kind != CauseInvalidOpcode || site.Location != LocationSuffix {
+				t.Errorf("[%v] unexpected site %+v", tc.name, site)
+			}
+		}
+	}
+
+	// A possible failure in the prefix is the only site.
+	code, _ := hex.DecodeString("600035565b00")
+	a := newTestAnalyzer(t, Config{})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	for _, pc := range []uint64{0, 2, 3} {
+		a.AppendPrefixInstruction(1, pc)
+	}
+	sites, err := a.FailureSites(context.Background(), 1)
+	if err != nil || len(sites) != 1 || sites[0].PC != 3 || sites[0].Location != LocationPrefix || sites[0].Cause.Kind != CauseJumpToTop {
+		t.Errorf("expected failure in the prefix, but got %+v (error: %v)", sites, err)
+	}
+}
+
+func TestReachableTargets(t *testing.T) {
+	// The JUMPI at PC 5 may reach the assertion at PC 6 or jump to PC 10, which jumps to the STOP at PC 16.
+	branching := "600035600a57fe0000005b600f56005b00"
+	branchingCode, _ := hex.DecodeString(branching)
+	branchingHash := crypto.Keccak256Hash(branchingCode).Bytes()
+	targetTests := []struct {
+		name     string
+		code     string
+		setup    func(a *LookaheadAnalyzer)
+		expected []ReachableTarget
+	}{
+		{
+			name:     "assertions",
+			code:     branching,
+			expected: []ReachableTarget{{PC: 6, Kind: AssertionKind, Cause: FailureCause{Kind: CauseInvalidOpcode}, Distance: 1}},
+		},
+		{
+			name: "covered-assertions",
+			code: branching,
+			setup: func(a *LookaheadAnalyzer) {
+				a.RecordCoveredAssertion(branchingHash, 6)
+			},
+		},
+		{
+			// Assertions are no targets if there are target instructions.
+			name: "target-instructions",
+			code: branching,
+			setup: func(a *LookaheadAnalyzer) {
+				a.AddTargetInstruction(branchingHash, 16)
+			},
+			expected: []ReachableTarget{{PC: 16, Kind: TargetInstructionKind, Cause: FailureCause{Kind: CauseReachedTargetInstruction}, Distance: 2}},
+		},
+		{
+			name: "registered-targets",
+			code: branching,
+			setup: func(a *LookaheadAnalyzer) {
+				if err := a.AddTargetSpec("STOP"); err != nil {
+					t.Fatalf("unexpected invalid target: %v", err)
+				}
+			},
+			expected: []ReachableTarget{{PC: 16, Kind: RegisteredTargetKind, Cause: FailureCause{Kind: CauseReachedTarget, Detail: "STOP"}, Distance: 2}},
+		},
+		{
+			// Reverts with Panic(0x11).
+			name: "panics",
+			code: "634e487b7160e01b600052601160045260246000fd",
+			setup: func(a *LookaheadAnalyzer) {
+				a.TargetPanics(0x11)
+			},
+			expected: []ReachableTarget{{PC: 20, Kind: PanicKind, Cause: FailureCause{Kind: CauseReachedPanic, Detail: "0x11"}}},
+		},
+		{
+			// Reverts with the custom error 0x12345678.
+			name: "revert-errors",
+			code: "631234567860e01b60005260046000fd",
+			setup: func(a *LookaheadAnalyzer) {
+				a.AddTargetCustomError("E", [4]byte{0x12, 0x34, 0x56, 0x78})
+			},
+			expected: []ReachableTarget{{PC: 15, Kind: RevertErrorKind, Cause: FailureCause{Kind: CauseReachedRevertError, Detail: "E"}}},
+		},
+		{
+			// Emits an event with topic 1.
+			name: "events",
+			code: "600160006000a100",
+			setup: func(a *LookaheadAnalyzer) {
+				a.AddTargetEvent("E", common.BigToHash(big.NewInt(1)))
+			},
+			expected: []ReachableTarget{{PC: 6, Kind: EventKind, Cause: FailureCause{Kind: CauseReachedEvent, Detail: "E"}}},
+		},
+	}
+	for _, tc := range targetTests {
+		code, err := hex.DecodeString(tc.code)
+		if err != nil {
+			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
+			continue
+		}
+		a := newTestAnalyzer(t, Config{})
+		if tc.setup != nil {
+			tc.setup(a)
+		}
+		a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+		a.AppendPrefixInstruction(1, 0)
+		targets, complete, err := a.ReachableTargets(1)
+		if err != nil {
+			t.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			continue
+		}
+		if !complete || fmt.Sprint(targets) != fmt.Sprint(tc.expected) {
+			t.Errorf("[%v] expected targets %v, but got %v (complete: %v)", tc.name, tc.expected, targets, complete)
+		}
+		// The suffix analysis must agree on the targets.
+		canIgnore, _, cause, _, err := a.CanIgnoreSuffix(1)
+		if err != nil || canIgnore != (len(tc.expected) == 0) || (!canIgnore && cause != tc.expected[0].Cause.String()) {
+			t.Errorf("[%v] expected consistent suffix analysis, but got %v (failure cause '%v', error: %v)", tc.name, canIgnore, cause, err)
+		}
+	}
+
+	// The destination of the JUMP at PC 3 is unknown.
+	code, _ := hex.DecodeString("600035565b00")
+	a := newTestAnalyzer(t, Config{})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	if _, complete, err := a.ReachableTargets(1); err != nil || complete {
+		t.Errorf("expected incomplete targets (error: %v)", err)
+	}
+}
+
+func TestMagicValues(t *testing.T) {
+	// The call value is compared with 5 and the function selector with 0x12345678, which leads to the INVALID at
+	// PC 27. The comparison of the selector with 9 at PC 23 does not lead to a failure.
+	code, _ := hex.DecodeString("346005105060003560e01c80631234567814601a5760091450005bfe")
+	a := newTestAnalyzer(t, Config{})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	values, err := a.MagicValues(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("analysis ended with an error: %v", err)
+	}
+	expected := []MagicValue{
+		{Value: big.NewInt(5), PC: 3, Comparison: vm.LT, Source: InputSource{Opcode: vm.CALLVALUE}, HasSource: true},
+		{Value: big.NewInt(0x12345678), PC: 17, Comparison: vm.EQ, Source: InputSource{Opcode: vm.CALLDATALOAD, HasOffset: true}, HasSource: true},
+	}
+	if fmt.Sprint(values) != fmt.Sprint(expected) {
+		t.Errorf("expected magic values %v, but got %v", expected, values)
+	}
+}
+
+func TestBranchInputs(t *testing.T) {
+	// The JUMPI at PC 5 depends on the calldata word at offset 4, the one at PC 9 on the call value and the one at
+	// PC 18 on storage slot 0.
+	code, _ := hex.DecodeString("600435600c5734600c5700005b600054601457005b00")
+	a := newTestAnalyzer(t, Config{})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	branches, complete, err := a.BranchInputs(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("analysis ended with an error: %v", err)
+	}
+	var selector []uint64
+	for off := uint64(4); off < 36; off++ {
+		selector = append(selector, off)
+	}
+	expected := []BranchInputs{
+		{PC: 5, CalldataBytes: selector},
+		{PC: 9, CallValue: true},
+		{PC: 18, StorageSlots: []common.Hash{{}}},
+	}
+	if !complete || fmt.Sprint(branches) != fmt.Sprint(expected) {
+		t.Errorf("expected branch inputs %v, but got %v (complete: %v)", expected, branches, complete)
+	}
+
+	// The prefix copies 32 calldata bytes from offset 8 to memory, which the JUMPI at PC 12 loads.
+	code, _ = hex.DecodeString("60206008600037600051600e57005b00")
+	a = newTestAnalyzer(t, Config{})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	for _, pc := range []uint64{0, 2, 4, 6} {
+		a.AppendPrefixInstruction(1, pc)
+	}
+	branches, _, err = a.BranchInputs(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("analysis ended with an error: %v", err)
+	}
+	var copied []uint64
+	for off := uint64(8); off < 40; off++ {
+		copied = append(copied, off)
+	}
+	expected = []BranchInputs{{PC: 12, CalldataBytes: copied}}
+	if fmt.Sprint(branches) != fmt.Sprint(expected) {
+		t.Errorf("expected branch inputs %v, but got %v", expected, branches)
+	}
+
+	// The calldata bytes copied to memory are moved with MCOPY before the JUMPI at PC 19 loads them, and the JUMPI at
+	// PC 33 loads the calldata word at offset 0 from transient storage.
+	code, _ = hex.DecodeString("602060046000376020600060405e604051601557" + "005b60003560075d60075c602357005b00")
+	a = newTestAnalyzer(t, Config{Fork: ForkCancun})
+	a.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+	a.AppendPrefixInstruction(1, 0)
+	branches, complete, err = a.BranchInputs(context.Background(), 1)
+	if err != nil {
+		t.Fatalf("analysis ended with an error: %v", err)
+	}
+	var word []uint64
+	for off := uint64(0); off < 32; off++ {
+		word = append(word, off)
+	}
+	expected = []BranchInputs{{PC: 19, CalldataBytes: selector}, {PC: 33, CalldataBytes: word}}
+	if !complete || fmt.Sprint(branches) != fmt.Sprint(expected) {
+		t.Errorf("expected branch inputs %v, but got %v (complete: %v)", expected, branches, complete)
+	}
+}
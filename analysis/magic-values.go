@@ -0,0 +1,153 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"math/big"
+	"sort"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// InputSource describes an input that the fuzzer controls (e.g., a word of the calldata).
+type InputSource struct {
+	// Opcode is the instruction that reads the input (e.g., CALLDATALOAD or CALLVALUE).
+	Opcode vm.OpCode
+	// Offset is the calldata offset that is read (if HasOffset is true).
+	Offset    uint64
+	HasOffset bool
+}
+
+// MagicValue is a constant that is compared with a value that the analysis does not know.
+type MagicValue struct {
+	Value *big.Int
+	// PC is the PC of the comparison and Comparison its opcode (e.g., EQ).
+	PC         uint64
+	Comparison vm.OpCode
+	// Source is the input that the constant is compared with (if HasSource is true).
+	Source    InputSource
+	HasSource bool
+}
+
+// inputOps contains the instructions that read inputs.
+var inputOps = map[vm.OpCode]bool{
+	vm.CALLDATALOAD: true,
+	vm.CALLDATASIZE: true,
+	vm.CALLVALUE:    true,
+	vm.CALLER:       true,
+	vm.ORIGIN:       true,
+	vm.TIMESTAMP:    true,
+	vm.NUMBER:       true,
+}
+
+// inputSourceBackwards determines the input from which the stack element at the given index (before executing the
+// instruction at the given PC) was derived.
+func inputSourceBackwards(contract *vm.Contract, ppcMap *prevPCMap, pc pcType, idx int, maxSteps int) (InputSource, bool) {
+	srcPC, op, found := stackSourceBackwards(contract, ppcMap, pc, idx, maxSteps, true)
+	if !found || !inputOps[op] {
+		return InputSource{}, false
+	}
+	src := InputSource{Opcode: op}
+	if op == vm.CALLDATALOAD {
+		if ppc, exists := ppcMap.getPrevPC(srcPC); exists {
+			if match, args, _ := matchesBackwards(contract, ppcMap, ppc, []vm.OpCode{vm.PUSH}); match && args[0].pushArg.IsUint64() {
+				src.Offset, src.HasOffset = args[0].pushArg.Uint64(), true
+			}
+		}
+	}
+	return src, true
+}
+
+// recordComparison records the constants that the given state compares with unknown values.
+func (f *fixpoint) recordComparison(loc string, pc pcType, op vm.OpCode, st absState) {
+	switch op {
+	case vm.EQ, vm.LT, vm.GT, vm.SLT, vm.SGT:
+	default:
+		return
+	}
+	if st.isBot || st.stack.isTop || st.stack.stack == nil || st.stack.len() < 2 {
+		return
+	}
+	for idx := 0; idx < 2; idx++ {
+		val, other := st.stack.stack.Back(idx), st.stack.stack.Back(1-idx)
+		if isTop(val) || !isTop(other) {
+			continue
+		}
+		mv := MagicValue{
+			Value:      new(big.Int).Set(val),
+			PC:         uint64(pc),
+			Comparison: op,
+		}
+		mv.Source, mv.HasSource = inputSourceBackwards(f.analyzer.contract, f.ppcMap, pc, 1-idx, f.analyzer.analyzer.config.EnvSourceSteps)
+		f.comparisons[loc] = append(f.comparisons[loc], mv)
+	}
+}
+
+// magicValues returns the recorded constants of comparisons on paths to possible failures (ordered by PC).
+func (f *fixpoint) magicValues() []MagicValue {
+	type magicKey struct {
+		pc     uint64
+		value  string
+		source InputSource
+	}
+	onPath := f.reachesFailure()
+	seen := map[magicKey]bool{}
+	var values []MagicValue
+	for loc, mvs := range f.comparisons {
+		if !onPath[loc] {
+			continue
+		}
+		for _, mv := range mvs {
+			key := magicKey{pc: mv.PC, value: mv.Value.String(), source: mv.Source}
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			values = append(values, mv)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].PC != values[j].PC {
+			return values[i].PC < values[j].PC
+		}
+		return values[i].Value.Cmp(values[j].Value) < 0
+	})
+	return values
+}
+
+// reachesFailure returns the locations of the states from which a possible failure was reached.
+func (f *fixpoint) reachesFailure() map[string]bool {
+	preds := map[string][]string{}
+	for loc, succs := range f.succs {
+		for succ := range succs {
+			preds[succ] = append(preds[succ], loc)
+		}
+	}
+	reached := map[string]bool{}
+	worklist := append([]string{}, f.failureLocs...)
+	for 0 < len(worklist) {
+		loc := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		if reached[loc] {
+			continue
+		}
+		reached[loc] = true
+		worklist = append(worklist, preds[loc]...)
+	}
+	return reached
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"crypto/sha256"
+)
+
+// checkpointInterval is the number of prefix instructions after which a checkpoint is created (in addition to the
+// checkpoint at the end of every analyzed prefix).
+const checkpointInterval = 64
+
+// maxCheckpointNodes is the number of trie nodes after which all checkpoints are discarded.
+const maxCheckpointNodes = 1 << 20
+
+// prefixCheckpoint is the result of the prefix analysis after a number of prefix instructions.
+type prefixCheckpoint struct {
+	res    stepRes
+	ppcMap *prevPCMap
+}
+
+func newPrefixCheckpoint(res stepRes, ppcMap *prevPCMap) *prefixCheckpoint {
+	return &prefixCheckpoint{
+		res:    copyRes(res),
+		ppcMap: ppcMap.clone(),
+	}
+}
+
+// resume returns copies of the result and the map of previous PCs, which can be modified by the analysis.
+func (c *prefixCheckpoint) resume() (stepRes, *prevPCMap) {
+	return copyRes(c.res), c.ppcMap.clone()
+}
+
+// copyRes copies the post states of the given result since instructions may modify states in place.
+func copyRes(res stepRes) stepRes {
+	cp := res
+	cp.postStates = make([]pcAndSt, len(res.postStates))
+	for i, pcSt := range res.postStates {
+		cp.postStates[i] = pcAndSt{
+			pc: pcSt.pc,
+			st: pcSt.st.withStackCopy().withMemCopy(),
+		}
+	}
+	return cp
+}
+
+func (m *prevPCMap) clone() *prevPCMap {
+	cp := newPrevPCMap()
+	for pc, ppc := range m.prevPC {
+		cp.prevPC[pc] = ppc
+	}
+	for pc := range m.multiplePreds {
+		cp.multiplePreds[pc] = true
+	}
+	return cp
+}
+
+// prefixTrieNode represents a prefix. Its children extend the prefix by one instruction.
+type prefixTrieNode struct {
+	children   map[pcType]*prefixTrieNode
+	checkpoint *prefixCheckpoint
+}
+
+// prefixTrie contains checkpoints of the prefix analysis, which makes it possible to resume the analysis when a
+// prefix grows and to share work between prefixes with a common stem.
+// There is a separate root for every kind of initial state (e.g., for a given code, the first call in a sequence
+// starts from a different storage than later calls).
+type prefixTrie struct {
+	roots    map[[sha256.Size]byte]*prefixTrieNode
+	numNodes int
+	// settings and config identify the settings of the analyzer for which the checkpoints were computed.
+	settings [sha256.Size]byte
+	config   [sha256.Size]byte
+}
+
+func newPrefixTrie(settings, config [sha256.Size]byte) *prefixTrie {
+	return &prefixTrie{
+		roots:    map[[sha256.Size]byte]*prefixTrieNode{},
+		settings: settings,
+		config:   config,
+	}
+}
+
+// isValid determines if the checkpoints can be used by an analyzer with the given settings.
+func (t *prefixTrie) isValid(settings, config [sha256.Size]byte) bool {
+	return t.settings == settings && t.config == config && t.numNodes < maxCheckpointNodes
+}
+
+// root returns the root for the given kind of initial state.
+func (t *prefixTrie) root(key [sha256.Size]byte) *prefixTrieNode {
+	n := t.roots[key]
+	if n == nil {
+		n = &prefixTrieNode{}
+		t.roots[key] = n
+		t.numNodes++
+	}
+	return n
+}
+
+// child returns the child of the given node for the given PC.
+// It returns nil if the trie is full.
+func (t *prefixTrie) child(n *prefixTrieNode, pc pcType) *prefixTrieNode {
+	c := n.children[pc]
+	if c != nil {
+		return c
+	}
+	if maxCheckpointNodes <= t.numNodes {
+		return nil
+	}
+	if n.children == nil {
+		n.children = map[pcType]*prefixTrieNode{}
+	}
+	c = &prefixTrieNode{}
+	n.children[pc] = c
+	t.numNodes++
+	return c
+}
+
+// lookup returns the last checkpoint along the given prefix together with the number of instructions that it
+// covers and the corresponding node. It returns a nil checkpoint if there is none.
+func (n *prefixTrieNode) lookup(prefix execPrefix) (*prefixCheckpoint, int, *prefixTrieNode) {
+	var cp *prefixCheckpoint
+	cpLen := 0
+	cpNode := n
+	curr := n
+	for idx := 0; curr != nil; idx++ {
+		if curr.checkpoint != nil {
+			cp, cpLen, cpNode = curr.checkpoint, idx, curr
+		}
+		pc, exists := prefix[idx]
+		if !exists {
+			break
+		}
+		curr = curr.children[pc]
+	}
+	return cp, cpLen, cpNode
+}
@@ -0,0 +1,197 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// TargetKind is the kind of a reachable target.
+type TargetKind int
+
+const (
+	// TargetInstructionKind is an instruction that was registered as a target (e.g., with AddTargetInstruction).
+	TargetInstructionKind TargetKind = iota
+	// AssertionKind is an invalid instruction (e.g., an assertion that has not been covered yet).
+	AssertionKind
+	// PanicKind is a revert with a targeted panic code.
+	PanicKind
+	// RevertErrorKind is a revert with a targeted error.
+	RevertErrorKind
+	// EventKind is an instruction that may emit a targeted event.
+	EventKind
+	// RegisteredTargetKind is an instruction at which a registered target (see Target) may fire.
+	RegisteredTargetKind
+)
+
+func (k TargetKind) String() string {
+	switch k {
+	case AssertionKind:
+		return "assertion"
+	case PanicKind:
+		return "panic"
+	case RevertErrorKind:
+		return "revert-error"
+	case EventKind:
+		return "event"
+	case RegisteredTargetKind:
+		return "target"
+	default:
+		return "instruction"
+	}
+}
+
+// targetKind returns the kind of target that corresponds to the given failure kind.
+func targetKind(kind FailureKind) TargetKind {
+	switch kind {
+	case CauseInvalidOpcode:
+		return AssertionKind
+	case CauseReachedPanic:
+		return PanicKind
+	case CauseReachedRevertError:
+		return RevertErrorKind
+	case CauseReachedEvent, CauseReachedAssertion:
+		return EventKind
+	case CauseReachedTarget:
+		return RegisteredTargetKind
+	default:
+		return TargetInstructionKind
+	}
+}
+
+// ReachableTarget is a target that may be reached after the prefix.
+type ReachableTarget struct {
+	PC   uint64
+	Kind TargetKind
+	// Cause is the failure that CanIgnoreSuffix would report for the target (e.g., the name of a registered target).
+	Cause FailureCause
+	// Distance is the minimum number of basic blocks that are entered on an abstract path from the end of the prefix
+	// to the target (zero if the target is in the block in which the prefix ends).
+	Distance int
+}
+
+// reachableTargets returns the targets that the states of a collecting exploration reach, ordered by their distance.
+// Targets are the same as for the analysis of a suffix (see constPropAnalyzer.reachedTarget).
+func (f *fixpoint) reachableTargets() []ReachableTarget {
+	a := f.analyzer
+	var targets []ReachableTarget
+	indices := map[string]int{}
+	dists := f.blockDistances()
+	for loc, dist := range dists {
+		pc := f.locPCs[loc]
+		op := a.contract.GetOp(uint64(pc))
+		cause, reached := a.reachedTarget(pc, op, f.states[loc], f.absJt[op].valid)
+		if !reached {
+			continue
+		}
+		id := fmt.Sprintf("%x:%v", pc, cause)
+		if idx, found := indices[id]; found {
+			// There may be several states for the same PC.
+			if dist < targets[idx].Distance {
+				targets[idx].Distance = dist
+			}
+			continue
+		}
+		indices[id] = len(targets)
+		fc := parseFailureCause(cause)
+		targets = append(targets, ReachableTarget{PC: uint64(pc), Kind: targetKind(fc.Kind), Cause: fc, Distance: dist})
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Distance != targets[j].Distance {
+			return targets[i].Distance < targets[j].Distance
+		}
+		if targets[i].PC != targets[j].PC {
+			return targets[i].PC < targets[j].PC
+		}
+		return targets[i].Cause.String() < targets[j].Cause.String()
+	})
+	return targets
+}
+
+// blockDistances returns the minimum number of basic blocks that are entered on the way from an entry state to each
+// reachable state.
+// Since every edge enters either zero or one blocks, the distances are computed by a breadth-first search with a
+// double-ended queue (0-1 BFS).
+func (f *fixpoint) blockDistances() map[string]int {
+	dists := map[string]int{}
+	var deque []string
+	for loc := range f.entryLocs {
+		dists[loc] = 0
+		deque = append(deque, loc)
+	}
+	for 0 < len(deque) {
+		loc := deque[0]
+		deque = deque[1:]
+		op := f.analyzer.contract.GetOp(uint64(f.locPCs[loc]))
+		for succ := range f.succs[loc] {
+			weight := 0
+			if op == vm.JUMP || op == vm.JUMPI || f.analyzer.contract.GetOp(uint64(f.locPCs[succ])) == vm.JUMPDEST {
+				weight = 1
+			}
+			dist := dists[loc] + weight
+			if old, found := dists[succ]; found && old <= dist {
+				continue
+			}
+			dists[succ] = dist
+			if weight == 0 {
+				deque = append([]string{succ}, deque...)
+			} else {
+				deque = append(deque, succ)
+			}
+		}
+	}
+	return dists
+}
+
+// ReachableTargets determines the targets (i.e., the instructions at which CanIgnoreSuffix would report a possible
+// failure) that may be reached after the prefix. Possible failures are ignored and the whole suffix is explored.
+// It also returns false if some paths could not be followed (e.g., jumps to unknown destinations), in which case
+// further targets may be reachable.
+func (a *constPropAnalyzer) ReachableTargets(execPrefix execPrefix) ([]ReachableTarget, bool, error) {
+	concJt := a.interpreter.Cfg.JumpTable
+	prefixRes, err := a.calculatePrecondition(concJt, a.newAbsJumpTable(true), execPrefix)
+	if err != nil {
+		return nil, false, err
+	}
+	if prefixRes.mayFail && prefixRes.failureCause == BudgetExceededFail {
+		return nil, false, fmt.Errorf("analysis exceeded its budget")
+	}
+	if prefixRes.mayFail {
+		return nil, false, nil
+	}
+
+	fp := a.newFixpoint(concJt, a.newAbsJumpTable(false))
+	fp.collect = true
+	if prefixLen := len(execPrefix); prefixLen == 0 {
+		fp.addNewStates(nil, prefixRes.postStates)
+	} else {
+		lastPrefixPC := execPrefix[prefixLen-1]
+		fp.addNewStates(&lastPrefixPC, prefixRes.postStates)
+	}
+	res, err := fp.run()
+	if err != nil {
+		return nil, false, err
+	}
+	if res.mayFail && res.failureCause == BudgetExceededFail {
+		return nil, false, fmt.Errorf("analysis exceeded its budget")
+	}
+	return fp.reachableTargets(), !fp.incomplete, nil
+}
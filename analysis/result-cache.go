@@ -0,0 +1,388 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+const numCacheShards = 64
+
+// cacheEntryOverhead is an estimate of the memory (in bytes) that an entry uses in addition to its key and failure
+// cause (i.e., for the map entry, the list element and the remaining fields).
+const cacheEntryOverhead = 128
+
+// resultCache is a cache of analysis results that can be used by several sessions at the same time.
+// It is split into shards with separate locks to reduce contention between workers. Each shard evicts its least
+// recently used results once it exceeds its share of the byte budget.
+type resultCache struct {
+	shards      [numCacheShards]cacheShard
+	shardBudget int
+
+	hits      atomic.Uint64
+	misses    atomic.Uint64
+	evictions atomic.Uint64
+}
+
+type cacheShard struct {
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element
+	// lru contains the entries from the most recently to the least recently used one.
+	lru  *list.List
+	size int
+}
+
+type cacheEntry struct {
+	key cacheKey
+	res result
+}
+
+func (e *cacheEntry) size() int {
+	return len(e.key) + len(e.res.failureCause) + cacheEntryOverhead
+}
+
+// newResultCache creates a cache that uses at most (approximately) the given number of bytes.
+func newResultCache(budget int) *resultCache {
+	c := &resultCache{
+		shardBudget: budget / numCacheShards,
+	}
+	for i := range c.shards {
+		c.shards[i].reset()
+	}
+	return c
+}
+
+func (s *cacheShard) reset() {
+	s.entries = map[cacheKey]*list.Element{}
+	s.lru = list.New()
+	s.size = 0
+}
+
+func (c *resultCache) shard(key cacheKey) *cacheShard {
+	return &c.shards[binary.LittleEndian.Uint64(key[:8])%numCacheShards]
+}
+
+func (c *resultCache) get(key cacheKey) (result, bool) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, found := s.entries[key]
+	if !found {
+		c.misses.Add(1)
+		return result{}, false
+	}
+	c.hits.Add(1)
+	s.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).res, true
+}
+
+func (c *resultCache) put(key cacheKey, res result) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if elem, found := s.entries[key]; found {
+		e := elem.Value.(*cacheEntry)
+		s.size += len(res.failureCause) - len(e.res.failureCause)
+		e.res = res
+		s.lru.MoveToFront(elem)
+	} else {
+		e := &cacheEntry{key: key, res: res}
+		s.entries[key] = s.lru.PushFront(e)
+		s.size += e.size()
+	}
+	for c.shardBudget < s.size && 0 < s.lru.Len() {
+		oldest := s.lru.Back()
+		e := oldest.Value.(*cacheEntry)
+		s.lru.Remove(oldest)
+		delete(s.entries, e.key)
+		s.size -= e.size()
+		c.evictions.Add(1)
+	}
+}
+
+// clear removes all results.
+func (c *resultCache) clear() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		s.reset()
+		s.mu.Unlock()
+	}
+}
+
+// removeMayFail removes all results with a possible failure.
+func (c *resultCache) removeMayFail() {
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		for elem := s.lru.Front(); elem != nil; {
+			next := elem.Next()
+			if e := elem.Value.(*cacheEntry); e.res.mayFail {
+				s.lru.Remove(elem)
+				delete(s.entries, e.key)
+				s.size -= e.size()
+			}
+			elem = next
+		}
+		s.mu.Unlock()
+	}
+}
+
+// sizes returns the number of results and the estimated number of bytes that they use.
+func (c *resultCache) sizes() (int, int) {
+	var num, size int
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		num += s.lru.Len()
+		size += s.size
+		s.mu.Unlock()
+	}
+	return num, size
+}
+
+// entries returns the cached results from the least recently to the most recently used one (per shard).
+func (c *resultCache) entries() []cacheEntry {
+	var entries []cacheEntry
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.mu.Lock()
+		for elem := s.lru.Back(); elem != nil; elem = elem.Prev() {
+			entries = append(entries, *elem.Value.(*cacheEntry))
+		}
+		s.mu.Unlock()
+	}
+	return entries
+}
+
+// cacheMagic identifies files that contain saved caches.
+var cacheMagic = []byte("bran-cache")
+
+// cacheFormatVersion is the version of the format of saved caches.
+const cacheFormatVersion = 5
+
+// maxSavedCauseLen is the maximum length of a failure cause in a saved cache, which avoids huge allocations when
+// loading corrupted files.
+const maxSavedCauseLen = 1 << 20
+
+// maxSavedLocLen is the maximum length of the location of a covered assertion (i.e., "<code hash>:<pc>") in a saved
+// cache.
+const maxSavedLocLen = 256
+
+// maxSavedCovered and maxSavedEntries are the maximum numbers of covered assertions and of entries in a saved cache,
+// which reject corrupted files before reading them.
+const (
+	maxSavedCovered = 1 << 20
+	maxSavedEntries = 1 << 32
+)
+
+// analysisVersion needs to be incremented whenever the analysis changes in a way that may affect results, which
+// makes saved caches stale.
+const analysisVersion = 2
+
+type cacheHeader struct {
+	FormatVersion   uint32
+	AnalysisVersion uint32
+	Config          [sha256.Size]byte
+	Settings        [sha256.Size]byte
+	// NumCovered is the number of covered assertions, which follow the header.
+	NumCovered uint64
+	NumEntries uint64
+}
+
+const (
+	cacheFlagMayFail    = 1
+	cacheFlagAvoidRetry = 2
+	cacheFlagInPrefix   = 4
+	// cacheFlagHasPC is set if the flags are followed by the PC of the possible failure.
+	cacheFlagHasPC = 8
+)
+
+// SaveCache writes the cached results to the given writer.
+// Results are keyed by the code hash, the prefix and the settings of the analyzer (e.g., the configuration and the
+// targets). They can only be loaded by an analyzer with the same settings.
+func (a *LookaheadAnalyzer) SaveCache(w io.Writer) error {
+	a.mu.RLock()
+	header := cacheHeader{
+		FormatVersion:   cacheFormatVersion,
+		AnalysisVersion: analysisVersion,
+		Config:          a.configHash,
+		Settings:        a.settings,
+	}
+	covered := sortedKeys(a.isCoveredAssertion)
+	entries := a.cache.entries()
+	a.mu.RUnlock()
+	header.NumCovered = uint64(len(covered))
+	header.NumEntries = uint64(len(entries))
+	if maxSavedCovered < header.NumCovered || maxSavedEntries < header.NumEntries {
+		return fmt.Errorf("too many results to save")
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.Write(cacheMagic)
+	if err := binary.Write(bw, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	for _, loc := range covered {
+		if maxSavedLocLen < len(loc) {
+			return fmt.Errorf("overly long location of covered assertion '%v'", loc)
+		}
+		if err := binary.Write(bw, binary.LittleEndian, uint32(len(loc))); err != nil {
+			return err
+		}
+		bw.WriteString(loc)
+	}
+	for _, e := range entries {
+		var flags byte
+		if e.res.mayFail {
+			flags |= cacheFlagMayFail
+		}
+		if e.res.avoidRetry {
+			flags |= cacheFlagAvoidRetry
+		}
+		if e.res.inPrefix {
+			flags |= cacheFlagInPrefix
+		}
+		if e.res.hasFailurePC {
+			flags |= cacheFlagHasPC
+		}
+		bw.Write(e.key[:])
+		bw.WriteByte(flags)
+		if e.res.hasFailurePC {
+			if err := binary.Write(bw, binary.LittleEndian, uint64(e.res.failurePC)); err != nil {
+				return err
+			}
+		}
+		if maxSavedCauseLen < len(e.res.failureCause) {
+			return fmt.Errorf("overly long failure cause for cache entry %x", e.key)
+		}
+		if err := binary.Write(bw, binary.LittleEndian, uint32(len(e.res.failureCause))); err != nil {
+			return err
+		}
+		bw.WriteString(e.res.failureCause)
+	}
+	return bw.Flush()
+}
+
+// LoadCache adds the results that were saved by SaveCache and returns the number of added results.
+// The results are rejected if they were saved by a different version of the analysis or by an analyzer with
+// different settings. Since covering assertions only removes targets, results without a possible failure are added if
+// all assertions that were covered when saving them are covered, and results with a possible failure are added if no
+// further assertions are covered.
+func (a *LookaheadAnalyzer) LoadCache(r io.Reader) (int, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(cacheMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, cacheMagic) {
+		return 0, fmt.Errorf("invalid cache file")
+	}
+	var header cacheHeader
+	if err := binary.Read(br, binary.LittleEndian, &header); err != nil {
+		return 0, fmt.Errorf("invalid cache file: %v", err)
+	}
+	if header.FormatVersion != cacheFormatVersion {
+		return 0, fmt.Errorf("unsupported cache format version %v", header.FormatVersion)
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if header.AnalysisVersion != analysisVersion {
+		return 0, fmt.Errorf("stale cache from analysis version %v", header.AnalysisVersion)
+	}
+	if header.Config != a.configHash || header.Settings != a.settings {
+		return 0, fmt.Errorf("stale cache from an analyzer with different settings")
+	}
+	if maxSavedCovered < header.NumCovered || maxSavedEntries < header.NumEntries {
+		return 0, fmt.Errorf("invalid cache file: too many results")
+	}
+	savedCovered := map[string]bool{}
+	for i := uint64(0); i < header.NumCovered; i++ {
+		var locLen uint32
+		if err := binary.Read(br, binary.LittleEndian, &locLen); err != nil {
+			return 0, fmt.Errorf("invalid covered assertion: %v", err)
+		}
+		if maxSavedLocLen < locLen {
+			return 0, fmt.Errorf("invalid covered assertion: overly long location")
+		}
+		loc := make([]byte, locLen)
+		if _, err := io.ReadFull(br, loc); err != nil {
+			return 0, fmt.Errorf("invalid covered assertion: %v", err)
+		}
+		savedCovered[string(loc)] = true
+	}
+	// keepNoFail is true if every assertion that was covered when saving is still covered and keepMayFail is true if
+	// no further assertions are covered.
+	keepNoFail, keepMayFail := true, true
+	for loc := range savedCovered {
+		keepNoFail = keepNoFail && a.isCoveredAssertion[loc]
+	}
+	for loc := range a.isCoveredAssertion {
+		keepMayFail = keepMayFail && savedCovered[loc]
+	}
+	var entries []cacheEntry
+	for i := uint64(0); i < header.NumEntries; i++ {
+		var e cacheEntry
+		var flags byte
+		var pc uint64
+		var causeLen uint32
+		if _, err := io.ReadFull(br, e.key[:]); err != nil {
+			return 0, fmt.Errorf("invalid cache entry: %v", err)
+		}
+		if err := binary.Read(br, binary.LittleEndian, &flags); err != nil {
+			return 0, fmt.Errorf("invalid cache entry: %v", err)
+		}
+		if flags&cacheFlagHasPC != 0 {
+			if err := binary.Read(br, binary.LittleEndian, &pc); err != nil {
+				return 0, fmt.Errorf("invalid cache entry: %v", err)
+			}
+		}
+		if err := binary.Read(br, binary.LittleEndian, &causeLen); err != nil {
+			return 0, fmt.Errorf("invalid cache entry: %v", err)
+		}
+		if maxSavedCauseLen < causeLen {
+			return 0, fmt.Errorf("invalid cache entry: overly long failure cause")
+		}
+		cause := make([]byte, causeLen)
+		if _, err := io.ReadFull(br, cause); err != nil {
+			return 0, fmt.Errorf("invalid cache entry: %v", err)
+		}
+		e.res = result{
+			mayFail:      flags&cacheFlagMayFail != 0,
+			avoidRetry:   flags&cacheFlagAvoidRetry != 0,
+			failureCause: string(cause),
+			failurePC:    pcType(pc),
+			hasFailurePC: flags&cacheFlagHasPC != 0,
+			inPrefix:     flags&cacheFlagInPrefix != 0,
+		}
+		if (e.res.mayFail && keepMayFail) || (!e.res.mayFail && keepNoFail) {
+			entries = append(entries, e)
+		}
+	}
+	// Results are only added if the whole file is valid.
+	for _, e := range entries {
+		a.cache.put(e.key, e.res)
+	}
+	return len(entries), nil
+}
@@ -0,0 +1,264 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Verdict is the outcome of analyzing a suffix.
+type Verdict int
+
+const (
+	// VerdictUnknown means that the suffix was not analyzed (e.g., due to an error or since the analysis is disabled).
+	VerdictUnknown Verdict = iota
+	// VerdictCanIgnore means that the suffix cannot reach a target.
+	VerdictCanIgnore
+	// VerdictMayFail means that the suffix may reach a target (or that the analysis cannot rule it out).
+	VerdictMayFail
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictCanIgnore:
+		return "can-ignore"
+	case VerdictMayFail:
+		return "may-fail"
+	default:
+		return "unknown"
+	}
+}
+
+// FailureKind is the kind of a possible failure.
+type FailureKind int
+
+const (
+	CauseNone FailureKind = iota
+	CauseReachedTargetInstruction
+	CauseReachedAssertion
+	CauseReachedPanic
+	CauseReachedRevertError
+	CauseReachedEvent
+	CauseReachedTarget
+	CauseInvalidOpcode
+	CauseUnsupportedOpcode
+	CauseMemoryOverflow
+	CauseTopMemoryResize
+	CauseTopStack
+	CauseStackValidation
+	CauseJumpToTop
+	CauseTopOffset
+	CausePrefixComputation
+	CauseStepExec
+	CauseInternal
+	CauseBudgetExceeded
+	// CauseOther is used for causes that are not known to this version of the analysis (e.g., from saved caches).
+	CauseOther
+)
+
+// String returns the name that is used in justifications (e.g., InvalidOpcodeFail).
+func (k FailureKind) String() string {
+	switch k {
+	case CauseReachedTargetInstruction:
+		return ReachedTargetInstructionFail
+	case CauseReachedAssertion:
+		return ReachedAssertionFailed
+	case CauseReachedPanic:
+		return ReachedPanicFail
+	case CauseReachedRevertError:
+		return ReachedRevertErrorFail
+	case CauseReachedEvent:
+		return ReachedEventFail
+	case CauseReachedTarget:
+		return ReachedTargetFail
+	case CauseInvalidOpcode:
+		return InvalidOpcodeFail
+	case CauseUnsupportedOpcode:
+		return UnsupportedOpcodeFail
+	case CauseMemoryOverflow:
+		return MemoryOverflowFail
+	case CauseTopMemoryResize:
+		return TopMemoryResizeFail
+	case CauseTopStack:
+		return TopStackFail
+	case CauseStackValidation:
+		return StackValidationFail
+	case CauseJumpToTop:
+		return JumpToTopFail
+	case CauseTopOffset:
+		return TopOffsetFail
+	case CausePrefixComputation:
+		return PrefixComputationFail
+	case CauseStepExec:
+		return StepExecFail
+	case CauseInternal:
+		return InternalFail
+	case CauseBudgetExceeded:
+		return BudgetExceededFail
+	case CauseOther:
+		return "other"
+	default:
+		return ""
+	}
+}
+
+// FailureCause describes a possible failure.
+type FailureCause struct {
+	Kind FailureKind
+	// Detail identifies what was reached (e.g., the name of a target or a panic code). It contains the complete
+	// justification for causes of kind CauseOther.
+	Detail string
+}
+
+// String returns the cause as it appears in justifications (e.g., "reached-target(withdraw)").
+func (c FailureCause) String() string {
+	if c.Kind == CauseOther {
+		return c.Detail
+	}
+	if c.Detail == "" {
+		return c.Kind.String()
+	}
+	return fmt.Sprintf("%v(%v)", c.Kind, c.Detail)
+}
+
+// parseFailureCause parses a cause of the form "kind" or "kind(detail)".
+func parseFailureCause(s string) FailureCause {
+	if s == "" {
+		return FailureCause{}
+	}
+	name, detail := s, ""
+	if i := strings.IndexByte(s, '('); 0 <= i && strings.HasSuffix(s, ")") {
+		name, detail = s[:i], s[i+1:len(s)-1]
+	}
+	for k := CauseNone + 1; k < CauseOther; k++ {
+		if k.String() == name {
+			return FailureCause{Kind: k, Detail: detail}
+		}
+	}
+	return FailureCause{Kind: CauseOther, Detail: s}
+}
+
+// Location is the part of the execution in which a possible failure was found.
+type Location int
+
+const (
+	LocationNone Location = iota
+	LocationPrefix
+	LocationSuffix
+)
+
+func (l Location) String() string {
+	switch l {
+	case LocationPrefix:
+		return "prefix"
+	case LocationSuffix:
+		return "suffix"
+	default:
+		return "none"
+	}
+}
+
+// CacheStatus determines which cache (if any) provided a result.
+type CacheStatus int
+
+const (
+	// CacheNotUsed means that the cache was not consulted (e.g., since the analysis is disabled).
+	CacheNotUsed CacheStatus = iota
+	// CacheMiss means that the prefix and its suffix were analyzed.
+	CacheMiss
+	// CacheHit means that the result for the prefix was cached.
+	CacheHit
+	// SuffixCacheHit means that the prefix was analyzed, but the result for its suffix was cached.
+	SuffixCacheHit
+)
+
+func (s CacheStatus) String() string {
+	switch s {
+	case CacheMiss:
+		return "miss"
+	case CacheHit:
+		return "hit"
+	case SuffixCacheHit:
+		return "suffix-hit"
+	default:
+		return "not-used"
+	}
+}
+
+// Result is the result of analyzing the suffix of a call.
+type Result struct {
+	Verdict Verdict
+	// AvoidRetry is true if analyzing the same prefix again (e.g., with different settings) is not expected to help.
+	AvoidRetry bool
+	// Cause is the cause of the possible failure (if the verdict is VerdictMayFail).
+	Cause FailureCause
+	// FailurePC is the PC of the instruction at which the possible failure was found (if HasFailurePC is true).
+	FailurePC    uint64
+	HasFailurePC bool
+	// Location is the part of the execution in which the possible failure was found.
+	Location Location
+	// PrefixID identifies the analyzed prefix.
+	PrefixID string
+	Cache    CacheStatus
+	// Duration is the time that the analysis took.
+	Duration time.Duration
+	// NumStates is the number of abstract states that the analysis of the suffix explored (zero for cached results).
+	NumStates int
+	// Witness is the path from the end of the prefix to the possible failure. It is nil for possible failures in the
+	// prefix, for results of analyses that gave up and for cached results.
+	Witness *Witness
+
+	justification string
+}
+
+// Justification returns the justification of the possible failure as reported by CanIgnoreSuffix
+// (e.g., "prefix-computation-failure(top-stack)").
+func (r Result) Justification() string {
+	return r.justification
+}
+
+// newResult converts a result of the analysis.
+func newResult(res result, pid string, cache CacheStatus) Result {
+	r := Result{
+		Verdict:    VerdictCanIgnore,
+		AvoidRetry: res.avoidRetry,
+		PrefixID:   pid,
+		Cache:      cache,
+	}
+	if !res.mayFail {
+		return r
+	}
+	r.Verdict = VerdictMayFail
+	r.justification = res.failureCause
+	r.FailurePC, r.HasFailurePC = uint64(res.failurePC), res.hasFailurePC
+	cause := res.failureCause
+	if res.inPrefix {
+		r.Location = LocationPrefix
+		// Possible failures in the prefix are reported as a prefix computation failure that wraps the actual cause.
+		wrapper := PrefixComputationFail + "("
+		if strings.HasPrefix(cause, wrapper) && strings.HasSuffix(cause, ")") {
+			cause = cause[len(wrapper) : len(cause)-1]
+		}
+	} else {
+		r.Location = LocationSuffix
+	}
+	r.Cause = parseFailureCause(cause)
+	return r
+}
@@ -0,0 +1,181 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+)
+
+// Panic codes used by Solidity (since version 0.8) in Panic(uint256) reverts.
+const (
+	PanicGeneric          = 0x00
+	PanicAssert           = 0x01
+	PanicArithmetic       = 0x11
+	PanicDivisionByZero   = 0x12
+	PanicEnumConversion   = 0x21
+	PanicStorageEncoding  = 0x22
+	PanicEmptyArrayPop    = 0x31
+	PanicArrayOutOfBounds = 0x32
+	PanicOutOfMemory      = 0x41
+	PanicZeroFunction     = 0x51
+)
+
+// panicSelector is the selector of Panic(uint256).
+var panicSelector = []byte{0x4e, 0x48, 0x7b, 0x71}
+
+// errorStringSelector is the selector of Error(string) (as used by require and revert with a reason string).
+var errorStringSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// revertTarget represents a targeted revert payload.
+type revertTarget struct {
+	// name is used in failure causes.
+	name string
+	// selector is the 4-byte selector of the error.
+	selector []byte
+	// message is the pattern that an Error(string) message needs to match (nil for custom errors).
+	message *regexp.Regexp
+}
+
+// returnPayload returns the abstract bytes that a REVERT (or RETURN) instruction would return.
+// It returns nil if the payload is unknown (e.g., because its offset or size are top) or larger than the given size.
+func returnPayload(st absState, maxSize uint64) []absByte {
+	if st.stack.isTop || st.stack.len() < 2 || st.mem.isTop {
+		return nil
+	}
+	offset, size := st.stack.stack.Back(0), st.stack.stack.Back(1)
+	if isTop(offset) || isTop(size) || !offset.IsUint64() || !size.IsUint64() {
+		return nil
+	}
+	off, sz := offset.Uint64(), size.Uint64()
+	if maxSize < sz {
+		// We don't inspect large payloads.
+		return nil
+	}
+	data := st.mem.mem.Data()
+	payload := make([]absByte, sz)
+	for i := uint64(0); i < sz; i++ {
+		// Bytes beyond the current memory size are zero.
+		if off < uint64(len(data)) && i < uint64(len(data))-off {
+			payload[i] = absByte(data[off+i])
+		}
+	}
+	return payload
+}
+
+// mayHaveSelector determines if the given payload may start with the given selector.
+// A nil payload is unknown and may start with any selector.
+func mayHaveSelector(payload []absByte, selector []byte) bool {
+	if payload == nil {
+		return true
+	}
+	if len(payload) < len(selector) {
+		return false
+	}
+	for i, b := range selector {
+		if !payload[i].isTop() && byte(payload[i]) != b {
+			return false
+		}
+	}
+	return true
+}
+
+// payloadWord returns the 32-byte word at the given offset of the payload (or nil if it is unknown).
+func payloadWord(payload []absByte, offset int) *big.Int {
+	if payload == nil || len(payload) < offset+32 {
+		return nil
+	}
+	word := make([]byte, 32)
+	for i := range word {
+		b := payload[offset+i]
+		if b.isTop() {
+			return nil
+		}
+		word[i] = byte(b)
+	}
+	return new(big.Int).SetBytes(word)
+}
+
+// mayRevertWithPanic determines if the REVERT in the given state may return a targeted Panic(uint256) payload.
+// If so, it also returns a failure cause that includes the panic code (if known).
+func (a *constPropAnalyzer) mayRevertWithPanic(st absState) (string, bool) {
+	payload := returnPayload(st, uint64(a.analyzer.config.MaxRevertPayloadSize))
+	if payload != nil && len(payload) < len(panicSelector)+32 {
+		return "", false
+	}
+	if !mayHaveSelector(payload, panicSelector) {
+		return "", false
+	}
+	code := payloadWord(payload, len(panicSelector))
+	if code == nil {
+		return fmt.Sprintf("%v(unknown)", ReachedPanicFail), true
+	}
+	if !a.analyzer.isTargetPanicCode(code) {
+		return "", false
+	}
+	return fmt.Sprintf("%v(%#x)", ReachedPanicFail, code), true
+}
+
+// errorMessage decodes the message of an Error(string) payload (or returns nil if the message is unknown).
+func errorMessage(payload []absByte) []byte {
+	offset := payloadWord(payload, len(errorStringSelector))
+	if offset == nil || !offset.IsUint64() {
+		return nil
+	}
+	// The offset is relative to the end of the selector (and checked before adding to avoid overflows).
+	if uint64(len(payload)-len(errorStringSelector)) < offset.Uint64() {
+		return nil
+	}
+	start := uint64(len(errorStringSelector)) + offset.Uint64()
+	length := payloadWord(payload, int(start))
+	if length == nil || !length.IsUint64() {
+		return nil
+	}
+	start += 32
+	if uint64(len(payload)) < start || uint64(len(payload))-start < length.Uint64() {
+		return nil
+	}
+	msg := make([]byte, length.Uint64())
+	for i := range msg {
+		b := payload[start+uint64(i)]
+		if b.isTop() {
+			return nil
+		}
+		msg[i] = byte(b)
+	}
+	return msg
+}
+
+// mayRevertWithTargetError determines if the REVERT in the given state may return the payload of a targeted error.
+// If so, it also returns a failure cause that names the (first) matching error.
+func (a *constPropAnalyzer) mayRevertWithTargetError(st absState) (string, bool) {
+	payload := returnPayload(st, uint64(a.analyzer.config.MaxRevertPayloadSize))
+	for _, t := range a.analyzer.revertTargets {
+		if !mayHaveSelector(payload, t.selector) {
+			continue
+		}
+		if t.message != nil {
+			if msg := errorMessage(payload); msg != nil && !t.message.Match(msg) {
+				continue
+			}
+		}
+		return fmt.Sprintf("%v(%v)", ReachedRevertErrorFail, t.name), true
+	}
+	return "", false
+}
@@ -0,0 +1,487 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// Session holds the prefixes of the calls that are executed by one fuzzing worker.
+// Sessions of the same analyzer share its configuration, targets, cache and statistics. A session must only be
+// used by one goroutine at a time, but different sessions may be used concurrently.
+type Session struct {
+	analyzer  *LookaheadAnalyzer
+	callInfos map[uint64]*callInfo
+	// checkpoints makes it possible to resume the analysis of prefixes that share a stem with earlier ones.
+	checkpoints *prefixTrie
+}
+
+// NewSession creates a session for a worker.
+func (a *LookaheadAnalyzer) NewSession() *Session {
+	return &Session{
+		analyzer:  a,
+		callInfos: map[uint64]*callInfo{},
+	}
+}
+
+func (s *Session) Start(callNumber uint64, code, codeHash []byte) {
+	a := s.analyzer
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	s.start(callNumber, code, codeHash)
+}
+
+func (s *Session) start(callNumber uint64, code, codeHash []byte) *callInfo {
+	if callNumber < 1 {
+		s.callInfos = map[uint64]*callInfo{}
+	}
+	addr := s.analyzer.config.ContractAddress
+	ch := common.BytesToHash(codeHash)
+	info := callInfo{
+		codeHash:      ch,
+		contract:      newDummyContract(addr, code, ch),
+		prefix:        map[int]pcType{},
+		prefixHash:    fnv.New32a(),
+		summaryHash:   fnv.New32a(),
+		prefixDigest:  sha256.New(),
+		summaryDigest: sha256.New(),
+		layout:        AnalyzeCodeLayout(code),
+		// Every call starts a new transaction unless it is nested.
+		initialTransient:    initialStorage(zeroStorage),
+		lastNestedPrefixLen: -1,
+	}
+	s.callInfos[callNumber] = &info
+	return &info
+}
+
+// StartNestedCall starts the analysis of a call that reenters the contract while the call with the given parent call
+// number waits for a call to return. The parent's prefix is expected to end with that pending call.
+// Since both calls belong to the same transaction, the nested call starts from the parent's transient storage.
+func (s *Session) StartNestedCall(callNumber, parentCallNumber uint64, code, codeHash []byte) error {
+	a := s.analyzer
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	parent := s.callInfos[parentCallNumber]
+	if parent == nil {
+		return fmt.Errorf("parent call not yet started")
+	}
+	if callNumber < 1 || callNumber == parentCallNumber {
+		return fmt.Errorf("expected nested call to have a different, positive call number")
+	}
+	transient := unknownStorage()
+	if parent.prefixLen <= a.config.MaxPrefixLen {
+		analyzer, err := s.prepareAnalyzer(parentCallNumber, parent)
+		if err != nil {
+			return err
+		}
+		if st, err := analyzer.stateBeforeLastInstruction(parent.prefix); err == nil {
+			transient = st.transient
+		}
+	}
+	hasSibling := parent.lastNestedPrefixLen == parent.prefixLen
+	if hasSibling {
+		// An earlier nested call during the same pending call may have modified the transient storage.
+		transient = transient.clobber()
+	}
+	parent.lastNestedPrefixLen = parent.prefixLen
+
+	info := s.start(callNumber, code, codeHash)
+	info.initialTransient = transient
+	// Results for the nested call depend on the parent's prefix.
+	b := make([]byte, 13)
+	binary.LittleEndian.PutUint32(b, parent.prefixHash.Sum32())
+	binary.LittleEndian.PutUint64(b[4:], uint64(parent.prefixLen))
+	if hasSibling {
+		b[12] = 1
+	}
+	info.prefixHash.Write(b)
+	info.parentDigest = append(parent.prefixDigest.Sum(nil), b[4:]...)
+	info.prefixDigest.Write(info.parentDigest)
+	return nil
+}
+
+// StartCreation starts the analysis of a call that executes creation (init) code.
+// The constructor arguments that follow the code are unknown and the storage is initially empty.
+func (s *Session) StartCreation(callNumber uint64, code, codeHash []byte) {
+	a := s.analyzer
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	info := s.start(callNumber, code, codeHash)
+	info.isCreation = true
+}
+
+func (s *Session) AppendPrefixSummary(callNumber, callNumberToSummarize uint64) {
+	defer s.analyzer.recordTime(time.Now())
+
+	info := s.callInfos[callNumber]
+	if info == nil {
+		return
+	}
+	sumInfo := s.callInfos[callNumberToSummarize]
+	if sumInfo == nil {
+		return
+	}
+	prefixSum := sumInfo.prefixHash.Sum32()
+	prefixBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(prefixBytes, prefixSum)
+	info.summaryHash.Write(prefixBytes)
+	summarySum := sumInfo.summaryHash.Sum32()
+	summaryBytes := make([]byte, 4)
+	binary.LittleEndian.PutUint32(summaryBytes, summarySum)
+	info.summaryHash.Write(summaryBytes)
+	info.summaryDigest.Write(sumInfo.prefixDigest.Sum(nil))
+	info.summaryDigest.Write(sumInfo.summaryDigest.Sum(nil))
+}
+
+func (s *Session) AppendPrefixInstruction(callNumber uint64, pc uint64) {
+	a := s.analyzer
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	info := s.callInfos[callNumber]
+	if info == nil {
+		return
+	}
+	prefixLen := info.prefixLen
+	if prefixLen < a.config.MaxPrefixLen {
+		// We stop recording if it becomes too long.
+		info.prefix[prefixLen] = pcType(pc)
+	}
+	info.prefixLen++
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, pc)
+	info.prefixHash.Write(b)
+	info.prefixDigest.Write(b)
+}
+
+func (s *Session) CurrentPathID() string {
+	info := s.callInfos[0]
+	if info == nil {
+		return ""
+	}
+	pHash := prefixHash(info.prefixHash.Sum32())
+	sHash := info.summaryHash.Sum32()
+	return fmt.Sprintf("%x+%x", pHash, sHash)
+}
+
+func (s *Session) CanIgnoreSuffix(callNumber uint64) (canIgnore, avoidRetry bool, justification, prefixId string, err error) {
+	return s.CanIgnoreSuffixContext(context.Background(), callNumber)
+}
+
+// CanIgnoreSuffixContext is like CanIgnoreSuffix, but gives up once the context is cancelled (in which case it returns
+// the context's error).
+// Independently of the context, the analysis gives up once it exceeds the limits of the configuration (e.g.,
+// MaxIterations), in which case the suffix cannot be ignored and the justification is BudgetExceededFail.
+func (s *Session) CanIgnoreSuffixContext(ctx context.Context, callNumber uint64) (canIgnore, avoidRetry bool, justification, prefixId string, err error) {
+	res, err := s.AnalyzeSuffix(ctx, callNumber)
+	return res.Verdict == VerdictCanIgnore, res.AvoidRetry, res.Justification(), res.PrefixID, err
+}
+
+// AnalyzeSuffix is like CanIgnoreSuffixContext, but returns a structured result.
+func (s *Session) AnalyzeSuffix(ctx context.Context, callNumber uint64) (Result, error) {
+	a := s.analyzer
+	start := time.Now()
+	defer a.recordTime(start)
+	// Targets and settings must not change while the analysis runs.
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	info := s.callInfos[callNumber]
+	if info == nil {
+		return Result{}, fmt.Errorf("analysis not yet started")
+	}
+
+	if a.config.UseDummyAnalysis {
+		return Result{}, nil
+	}
+
+	if a.config.MaxPrefixLen < info.prefixLen {
+		return Result{AvoidRetry: true}, fmt.Errorf("overly long prefix")
+	}
+
+	pHash := prefixHash(info.prefixHash.Sum32())
+	sHash := info.summaryHash.Sum32()
+	pid := fmt.Sprintf("%x:%x", pHash, sHash)
+	key := a.cacheKey(callNumber, info)
+
+	if cachedRes, found := a.cache.get(key); found {
+		if !cachedRes.mayFail {
+			a.recordSuccess()
+		}
+		r := newResult(cachedRes, pid, CacheHit)
+		r.Duration = time.Since(start)
+		return r, nil
+	}
+
+	analyzer, err := s.prepareAnalyzer(callNumber, info)
+	if err != nil {
+		return Result{AvoidRetry: true, PrefixID: pid, Cache: CacheMiss}, err
+	}
+
+	analyzer.budget = newBudget(ctx, a.config)
+	res, prefixErr, suffixErr := analyzer.Analyze(info.prefix)
+	if ctxErr := ctx.Err(); ctxErr != nil && (prefixErr != nil || suffixErr != nil) {
+		return Result{PrefixID: pid, Cache: CacheMiss}, ctxErr
+	}
+	if prefixErr != nil {
+		a.recordError()
+		return Result{AvoidRetry: true, PrefixID: pid, Cache: CacheMiss}, prefixErr
+	}
+	if suffixErr != nil {
+		a.recordError()
+		return Result{PrefixID: pid, Cache: CacheMiss}, suffixErr
+	}
+
+	// We cache both kinds of results, but not errors (and results of analyses that gave up only if configured).
+	if a.config.isCacheable(res) {
+		a.cache.put(key, res)
+	}
+
+	if res.mayFail {
+		a.recordFailure(res.failureCause, res.inPrefix)
+	} else {
+		a.recordSuccess()
+	}
+	cache := CacheMiss
+	if analyzer.suffixCacheHit {
+		cache = SuffixCacheHit
+	}
+	r := newResult(res, pid, cache)
+	r.NumStates = analyzer.numStates
+	r.Witness = analyzer.witness
+	r.Duration = time.Since(start)
+	return r, nil
+}
+
+// FailureSites returns all possible failures that are reachable from the end of the prefix of the given call.
+// Unlike AnalyzeSuffix, it keeps exploring the suffix after a possible failure was found and its results are not
+// cached. A possible failure in the prefix is returned as the only site. If the analysis exceeds its budget, the sites
+// that were found so far are returned together with an error.
+func (s *Session) FailureSites(ctx context.Context, callNumber uint64) ([]FailureSite, error) {
+	analyzer, res, err := s.analyzeExhaustively(ctx, callNumber, false)
+	if analyzer == nil {
+		return nil, err
+	}
+	if res.inPrefix && res.failureCause != BudgetExceededFail {
+		return []FailureSite{newFailureSite(res, false)}, nil
+	}
+	return analyzer.failureSites, err
+}
+
+// MagicValues returns the constants that are compared with unknown values (e.g., calldata) on the paths from the end
+// of the prefix of the given call to possible failures, together with the inputs they are compared with (if known).
+// The fuzzer can add them to its dictionary. If the analysis exceeds its budget, the constants that were found so
+// far are returned together with an error.
+func (s *Session) MagicValues(ctx context.Context, callNumber uint64) ([]MagicValue, error) {
+	analyzer, _, err := s.analyzeExhaustively(ctx, callNumber, true)
+	if analyzer == nil {
+		return nil, err
+	}
+	return analyzer.magicValues, err
+}
+
+// analyzeExhaustively analyzes the suffix of the given call without stopping at possible failures and without using
+// the cache. It returns a nil analyzer if the suffix was not analyzed.
+func (s *Session) analyzeExhaustively(ctx context.Context, callNumber uint64, collectMagic bool) (*constPropAnalyzer, result, error) {
+	a := s.analyzer
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	info := s.callInfos[callNumber]
+	if info == nil {
+		return nil, result{}, fmt.Errorf("analysis not yet started")
+	}
+	if a.config.UseDummyAnalysis {
+		return nil, result{}, nil
+	}
+	if a.config.MaxPrefixLen < info.prefixLen {
+		return nil, result{}, fmt.Errorf("overly long prefix")
+	}
+
+	analyzer, err := s.prepareAnalyzer(callNumber, info)
+	if err != nil {
+		return nil, result{}, err
+	}
+	analyzer.budget = newBudget(ctx, a.config)
+	analyzer.exhaustive = true
+	analyzer.collectMagic = collectMagic
+	res, prefixErr, suffixErr := analyzer.Analyze(info.prefix)
+	if ctxErr := ctx.Err(); ctxErr != nil && (prefixErr != nil || suffixErr != nil) {
+		return nil, result{}, ctxErr
+	}
+	if prefixErr != nil {
+		return nil, result{}, prefixErr
+	}
+	if suffixErr != nil {
+		return nil, result{}, suffixErr
+	}
+	if res.failureCause == BudgetExceededFail {
+		return analyzer, res, fmt.Errorf("analysis exceeded its budget")
+	}
+	return analyzer, res, nil
+}
+
+// ReachableTargets returns the targets (i.e., the instructions at which CanIgnoreSuffix would report a possible failure)
+// that may be reached after the prefix of the given call, ordered by their distance from the end of the prefix.
+// If complete is false, the analysis could not follow some paths (e.g., jumps to unknown destinations or a possible
+// failure in the prefix) and further targets may be reachable.
+func (s *Session) ReachableTargets(callNumber uint64) (targets []ReachableTarget, complete bool, err error) {
+	a := s.analyzer
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	info := s.callInfos[callNumber]
+	if info == nil {
+		return nil, false, fmt.Errorf("analysis not yet started")
+	}
+	if a.config.UseDummyAnalysis {
+		return nil, false, nil
+	}
+	if a.config.MaxPrefixLen < info.prefixLen {
+		return nil, false, fmt.Errorf("overly long prefix")
+	}
+
+	analyzer, err := s.prepareAnalyzer(callNumber, info)
+	if err != nil {
+		return nil, false, err
+	}
+	analyzer.budget = newBudget(context.Background(), a.config)
+	return analyzer.ReachableTargets(info.prefix)
+}
+
+// BranchInputs returns the inputs (i.e., calldata bytes, the call value, the caller and storage slots) that may
+// influence the conditions of the conditional jumps that may be reached after the prefix of the given call, ordered by
+// PC. A fuzzer may mutate only these inputs to flip a given branch.
+// If complete is false, the analysis could not follow some paths (e.g., jumps to unknown destinations or a possible
+// failure in the prefix) and further branches may be reachable.
+func (s *Session) BranchInputs(ctx context.Context, callNumber uint64) (branches []BranchInputs, complete bool, err error) {
+	a := s.analyzer
+	defer a.recordTime(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	info := s.callInfos[callNumber]
+	if info == nil {
+		return nil, false, fmt.Errorf("analysis not yet started")
+	}
+	if a.config.UseDummyAnalysis {
+		return nil, false, nil
+	}
+	if a.config.MaxPrefixLen < info.prefixLen {
+		return nil, false, fmt.Errorf("overly long prefix")
+	}
+
+	analyzer, err := s.prepareAnalyzer(callNumber, info)
+	if err != nil {
+		return nil, false, err
+	}
+	analyzer.budget = newBudget(ctx, a.config)
+	return analyzer.BranchInputs(info.prefix)
+}
+
+// CodeLayout returns the layout of the code that is executed by the given call (or nil if the call was not started).
+// Among others, it contains the compiler version from the metadata trailer.
+func (s *Session) CodeLayout(callNumber uint64) *CodeLayout {
+	info := s.callInfos[callNumber]
+	if info == nil {
+		return nil
+	}
+	return info.layout
+}
+
+// prepareAnalyzer creates an analyzer for the given call and configures its initial state.
+// Analyzers are not kept after the analysis to avoid that calls keep them alive (they are cheap to recreate).
+// The caller is expected to hold the read lock of the analyzer.
+func (s *Session) prepareAnalyzer(callNumber uint64, info *callInfo) (*constPropAnalyzer, error) {
+	a := s.analyzer
+	evm := newDummyEVM(a.instructionSet())
+	interpreter, ok := evm.Interpreter().(*vm.EVMInterpreter)
+	if !ok {
+		return nil, fmt.Errorf("expected compatible EVM interpreter")
+	}
+	analyzer := newConstPropAnalyzer(info.contract, info.codeHash, info.layout, interpreter, a)
+	switch initialStateKind(callNumber, info) {
+	case creationState:
+		// The storage of a new contract is empty.
+		analyzer.initialStorage = zeroStorage
+		analyzer.hasUnknownArgs = true
+	case firstCallState:
+		// Only the first call in a sequence starts from the initial storage.
+		analyzer.initialStorage = a.initialStorage
+	default:
+		analyzer.initialStorage = nil
+	}
+	analyzer.initialTransient = info.initialTransient
+
+	if s.checkpoints == nil || !s.checkpoints.isValid(a.settings, a.configHash) {
+		s.checkpoints = newPrefixTrie(a.settings, a.configHash)
+	}
+	analyzer.checkpoints = s.checkpoints
+	analyzer.checkpointRoot = s.checkpoints.root(checkpointKey(callNumber, info))
+	return analyzer, nil
+}
+
+const (
+	// creationState is the initial state of creation code (with empty storage).
+	creationState byte = iota
+	// firstCallState is the initial state of the first call in a sequence (with the initial storage).
+	firstCallState
+	// laterCallState is the initial state of later calls (with unknown storage).
+	laterCallState
+)
+
+// initialStateKind determines from which kind of state the prefix analysis for the given call starts.
+func initialStateKind(callNumber uint64, info *callInfo) byte {
+	switch {
+	case info.isCreation:
+		return creationState
+	case callNumber < 1:
+		return firstCallState
+	default:
+		return laterCallState
+	}
+}
+
+// checkpointKey identifies the initial state of the prefix analysis for the given call.
+func checkpointKey(callNumber uint64, info *callInfo) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write(info.codeHash[:])
+	h.Write([]byte{initialStateKind(callNumber, info)})
+	h.Write(info.parentDigest)
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
@@ -0,0 +1,120 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+// These tests are meant to be run with the race detector (go test -race).
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const numWorkers = 8
+
+// runWorkers runs the given function in several goroutines and waits for them to finish.
+func runWorkers(fn func(worker int)) {
+	var wg sync.WaitGroup
+	for w := 0; w < numWorkers; w++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			fn(worker)
+		}(w)
+	}
+	wg.Wait()
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	for _, tc := range tests {
+		code, err := hex.DecodeString(tc.code)
+		if err != nil {
+			t.Errorf("[%v] error decoding contract code: %v", tc.name, tc.code)
+			continue
+		}
+		// All workers fuzz the same contract and share the analyzer's cache and statistics.
+		a := newTestAnalyzer(t, Config{})
+		errs := make(chan error, numWorkers)
+		runWorkers(func(worker int) {
+			s := a.NewSession()
+			s.Start(1, code, crypto.Keccak256Hash(code).Bytes())
+			for _, pc := range tc.prefix {
+				s.AppendPrefixInstruction(1, pc)
+			}
+			canIgnore, _, cause, _, err := s.CanIgnoreSuffix(1)
+			if err != nil {
+				errs <- fmt.Errorf("[%v] analysis ended with an error: %v", tc.name, err)
+			} else if canIgnore != tc.canIgnore {
+				errs <- fmt.Errorf("[%v] expected analysis result %v, but got %v (failure cause '%v')", tc.name, tc.canIgnore, canIgnore, cause)
+			}
+			a.RecordCoveredPath(fmt.Sprintf("%v:%v", worker, s.CurrentPathID()), tc.name)
+		})
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+
+		if tc.canIgnore && a.NumSuccess() != numWorkers {
+			t.Errorf("[%v] expected %v successful results, but got %v", tc.name, numWorkers, a.NumSuccess())
+		}
+		var numCauses uint64
+		for _, cnt := range a.FailureCauses() {
+			numCauses += cnt
+		}
+		if numCauses != a.NumFail()+a.NumPrefixFail() {
+			t.Errorf("[%v] expected %v failure causes, but got %v", tc.name, a.NumFail()+a.NumPrefixFail(), numCauses)
+		}
+		if cnt := a.CoveredPathsPerLID()[tc.name]; cnt != numWorkers {
+			t.Errorf("[%v] expected %v covered paths, but got %v", tc.name, numWorkers, cnt)
+		}
+	}
+}
+
+func TestConcurrentConfigurationChanges(t *testing.T) {
+	// The code stores a word at an offset from the calldata and reverts.
+	code, _ := hex.DecodeString("6000600035525f5ffd")
+	codeHash := crypto.Keccak256Hash(code)
+	a := newTestAnalyzer(t, Config{Fork: ForkCancun})
+	runWorkers(func(worker int) {
+		if worker%2 == 0 {
+			// Some workers keep changing the settings while others analyze.
+			for i := 0; i < 10; i++ {
+				a.SetEnvironment(Environment{Number: RangeValue(big.NewInt(1), big.NewInt(int64(i+1)))})
+				a.RecordCoveredAssertion(codeHash.Bytes(), uint64(i))
+				a.TargetPanics()
+				a.IsTargetPanicCode(big.NewInt(1))
+			}
+			return
+		}
+		s := a.NewSession()
+		for i := 0; i < 10; i++ {
+			s.Start(0, code, codeHash.Bytes())
+			for _, pc := range []uint64{0, 2} {
+				s.AppendPrefixInstruction(0, pc)
+			}
+			if _, _, _, _, err := s.CanIgnoreSuffix(0); err != nil {
+				t.Errorf("analysis ended with an error: %v", err)
+			}
+			s.CurrentPathID()
+		}
+	})
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// suffixKey identifies the result of a suffix analysis by its entry states.
+// Different prefixes often reach the same entry states (e.g., after the function dispatcher), in which case the
+// suffix only needs to be analyzed once. Besides the entry states, the key includes the code hash and the settings
+// of the analyzer (e.g., the targets).
+func (a *constPropAnalyzer) suffixKey(lastPrefixPC pcType, entryStates []pcAndSt) cacheKey {
+	h := sha256.New()
+	h.Write(a.codeHash[:])
+	h.Write(a.analyzer.settings[:])
+	h.Write(a.analyzer.configHash[:])
+	b := make([]byte, 17)
+	binary.LittleEndian.PutUint64(b, uint64(lastPrefixPC))
+	binary.LittleEndian.PutUint64(b[8:], uint64(len(entryStates)))
+	if a.hasUnknownArgs {
+		b[16] = 1
+	}
+	h.Write(b)
+	for _, pcSt := range entryStates {
+		writeUint64(h, uint64(pcSt.pc))
+		pcSt.st.writeCanonical(h)
+	}
+	var key cacheKey
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// writeCanonical writes a representation of the state that is the same for equal states.
+func (s absState) writeCanonical(h hash.Hash) {
+	if s.isBot {
+		h.Write([]byte{0})
+		return
+	}
+	h.Write([]byte{1})
+	switch {
+	case s.stack.isTop:
+		h.Write([]byte{0})
+	case s.stack.stack == nil:
+		h.Write([]byte{1})
+	default:
+		h.Write([]byte{2})
+		data := s.stack.stack.Data()
+		writeUint64(h, uint64(len(data)))
+		for _, v := range data {
+			h.Write(common.BigToHash(v).Bytes())
+		}
+	}
+	switch {
+	case s.mem.isTop:
+		h.Write([]byte{0})
+	case s.mem.mem == nil:
+		h.Write([]byte{1})
+	default:
+		h.Write([]byte{2})
+		writeUint64(h, uint64(s.mem.len()))
+		h.Write(s.mem.mem.Data())
+	}
+	s.storage.writeCanonical(h)
+	s.transient.writeCanonical(h)
+}
+
+// writeCanonical writes a representation of the storage that is the same for equal storages.
+func (s absStorage) writeCanonical(h hash.Hash) {
+	h.Write(snapshotVersion(s.initial).Bytes())
+	if s.clobbered {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+	keys := make([]common.Hash, 0, len(s.written))
+	for k := range s.written {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i][:], keys[j][:]) < 0
+	})
+	writeUint64(h, uint64(len(keys)))
+	for _, k := range keys {
+		h.Write(k[:])
+		h.Write(common.BigToHash(s.written[k]).Bytes())
+	}
+}
+
+func writeUint64(h hash.Hash, n uint64) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, n)
+	h.Write(b)
+}
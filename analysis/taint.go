@@ -0,0 +1,588 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// maxTaintedOffset is the smallest calldata or memory offset that is treated as unknown (larger offsets do not occur
+// in practice and would only make the taint analysis slower).
+const maxTaintedOffset = 1 << 32
+
+// maxTaintedCopySize is the largest size of a copy (e.g., CALLDATACOPY) that is tracked byte by byte.
+const maxTaintedCopySize = 1024
+
+// BranchInputs describes the inputs that may influence the condition of a conditional jump.
+type BranchInputs struct {
+	PC uint64
+	// CalldataBytes contains the offsets of the calldata bytes that influence the condition (in ascending order).
+	// If AnyCalldata is true, bytes at unknown offsets may do so as well.
+	CalldataBytes []uint64
+	AnyCalldata   bool
+	CalldataSize  bool
+	CallValue     bool
+	Caller        bool
+	// StorageSlots contains the storage slots that influence the condition (in ascending order). If AnyStorage is
+	// true, slots with unknown keys may do so as well.
+	StorageSlots []common.Hash
+	AnyStorage   bool
+}
+
+// taintSet is the set of inputs that a value may depend on.
+// Taint sets are never modified after they were created.
+type taintSet struct {
+	calldata     map[uint64]bool
+	anyCalldata  bool
+	calldataSize bool
+	callValue    bool
+	caller       bool
+	slots        map[common.Hash]bool
+	anySlot      bool
+}
+
+func calldataTaint(offset, size uint64) taintSet {
+	t := taintSet{calldata: map[uint64]bool{}}
+	for i := uint64(0); i < size; i++ {
+		t.calldata[offset+i] = true
+	}
+	return t
+}
+
+func (t taintSet) union(o taintSet) taintSet {
+	u := taintSet{
+		anyCalldata:  t.anyCalldata || o.anyCalldata,
+		calldataSize: t.calldataSize || o.calldataSize,
+		callValue:    t.callValue || o.callValue,
+		caller:       t.caller || o.caller,
+		anySlot:      t.anySlot || o.anySlot,
+	}
+	if 0 < len(t.calldata)+len(o.calldata) {
+		u.calldata = map[uint64]bool{}
+		for _, m := range []map[uint64]bool{t.calldata, o.calldata} {
+			for off := range m {
+				u.calldata[off] = true
+			}
+		}
+	}
+	if 0 < len(t.slots)+len(o.slots) {
+		u.slots = map[common.Hash]bool{}
+		for _, m := range []map[common.Hash]bool{t.slots, o.slots} {
+			for slot := range m {
+				u.slots[slot] = true
+			}
+		}
+	}
+	return u
+}
+
+// covers determines if the set contains all inputs of the given set.
+func (t taintSet) covers(o taintSet) bool {
+	if (o.anyCalldata && !t.anyCalldata) || (o.calldataSize && !t.calldataSize) || (o.callValue && !t.callValue) ||
+		(o.caller && !t.caller) || (o.anySlot && !t.anySlot) {
+		return false
+	}
+	for off := range o.calldata {
+		if !t.calldata[off] {
+			return false
+		}
+	}
+	for slot := range o.slots {
+		if !t.slots[slot] {
+			return false
+		}
+	}
+	return true
+}
+
+// taintStack contains the taint sets of the stack elements (from the bottom to the top). Elements below the known
+// ones (e.g., after joining stacks of different heights) have the rest taint.
+type taintStack struct {
+	elems []taintSet
+	rest  taintSet
+}
+
+func (s *taintStack) push(t taintSet) {
+	s.elems = append(s.elems, t)
+}
+
+func (s *taintStack) pop() taintSet {
+	if len(s.elems) == 0 {
+		return s.rest
+	}
+	t := s.elems[len(s.elems)-1]
+	s.elems = s.elems[:len(s.elems)-1]
+	return t
+}
+
+// back returns the taint of the element at the given index from the top.
+func (s *taintStack) back(idx int) taintSet {
+	if len(s.elems) <= idx {
+		return s.rest
+	}
+	return s.elems[len(s.elems)-1-idx]
+}
+
+func (s *taintStack) swap(n int) {
+	for len(s.elems) <= n {
+		// We make the elements explicit that are swapped with the top.
+		s.elems = append([]taintSet{s.rest}, s.elems...)
+	}
+	top := len(s.elems) - 1
+	s.elems[top], s.elems[top-n] = s.elems[top-n], s.elems[top]
+}
+
+// taintMem contains the taint sets of memory bytes. Bytes that are not contained have the taint of any, which is
+// also included in the taint of all other bytes (e.g., after writing to an unknown offset).
+type taintMem struct {
+	bytes map[uint64]taintSet
+	any   taintSet
+}
+
+func (m *taintMem) load(offset, size uint64) taintSet {
+	t := m.any
+	for i := uint64(0); i < size; i++ {
+		if b, found := m.bytes[offset+i]; found {
+			t = t.union(b)
+		}
+	}
+	return t
+}
+
+func (m *taintMem) loadAll() taintSet {
+	t := m.any
+	for _, b := range m.bytes {
+		t = t.union(b)
+	}
+	return t
+}
+
+func (m *taintMem) store(offset, size uint64, t taintSet) {
+	for i := uint64(0); i < size; i++ {
+		m.bytes[offset+i] = t
+	}
+}
+
+// taintStorage contains the taint sets of values that were written to (transient) storage. Slots that are not
+// contained have the taint of any, which is also included in the taint of all other slots (e.g., after writing to an
+// unknown slot).
+type taintStorage struct {
+	slots map[common.Hash]taintSet
+	any   taintSet
+}
+
+func (s *taintStorage) load(slot common.Hash) taintSet {
+	return s.any.union(s.slots[slot])
+}
+
+func (s *taintStorage) loadAll() taintSet {
+	t := s.any
+	for _, v := range s.slots {
+		t = t.union(v)
+	}
+	return t
+}
+
+func (s taintStorage) clone() taintStorage {
+	c := taintStorage{slots: map[common.Hash]taintSet{}, any: s.any}
+	for slot, t := range s.slots {
+		c.slots[slot] = t
+	}
+	return c
+}
+
+// joinTaintStorage joins two storages and determines if the result differs from the first one.
+func joinTaintStorage(s1, s2 taintStorage) (taintStorage, bool) {
+	j := taintStorage{slots: map[common.Hash]taintSet{}, any: s1.any.union(s2.any)}
+	changed := !s1.any.covers(s2.any)
+	for _, m := range []map[common.Hash]taintSet{s1.slots, s2.slots} {
+		for slot := range m {
+			if _, found := j.slots[slot]; found {
+				continue
+			}
+			t1, t2 := s1.load(slot), s2.load(slot)
+			j.slots[slot] = t1.union(t2)
+			changed = changed || !t1.covers(t2)
+		}
+	}
+	return j, changed
+}
+
+type taintState struct {
+	stack     taintStack
+	mem       taintMem
+	storage   taintStorage
+	transient taintStorage
+}
+
+func emptyTaintState() taintState {
+	return taintState{
+		mem:       taintMem{bytes: map[uint64]taintSet{}},
+		storage:   taintStorage{slots: map[common.Hash]taintSet{}},
+		transient: taintStorage{slots: map[common.Hash]taintSet{}},
+	}
+}
+
+func (s taintState) clone() taintState {
+	c := taintState{
+		stack:     taintStack{elems: append([]taintSet{}, s.stack.elems...), rest: s.stack.rest},
+		mem:       taintMem{bytes: map[uint64]taintSet{}, any: s.mem.any},
+		storage:   s.storage.clone(),
+		transient: s.transient.clone(),
+	}
+	for off, t := range s.mem.bytes {
+		c.mem.bytes[off] = t
+	}
+	return c
+}
+
+// joinTaintStates joins two states and determines if the result differs from the first one.
+// Stacks are aligned at the top.
+func joinTaintStates(s1, s2 taintState) (taintState, bool) {
+	j := emptyTaintState()
+	n1, n2 := len(s1.stack.elems), len(s2.stack.elems)
+	n := n1
+	if n2 < n {
+		n = n2
+	}
+	j.stack.rest = s1.stack.rest.union(s2.stack.rest)
+	for _, t := range s1.stack.elems[:n1-n] {
+		j.stack.rest = j.stack.rest.union(t)
+	}
+	for _, t := range s2.stack.elems[:n2-n] {
+		j.stack.rest = j.stack.rest.union(t)
+	}
+	changed := n < n1 || !s1.stack.rest.covers(j.stack.rest)
+	for i := 0; i < n; i++ {
+		t1, t2 := s1.stack.elems[n1-n+i], s2.stack.elems[n2-n+i]
+		j.stack.elems = append(j.stack.elems, t1.union(t2))
+		changed = changed || !t1.covers(t2)
+	}
+	j.mem.any = s1.mem.any.union(s2.mem.any)
+	changed = changed || !s1.mem.any.covers(s2.mem.any)
+	for _, m := range []map[uint64]taintSet{s1.mem.bytes, s2.mem.bytes} {
+		for off := range m {
+			if _, found := j.mem.bytes[off]; found {
+				continue
+			}
+			t1, t2 := s1.mem.load(off, 1), s2.mem.load(off, 1)
+			j.mem.bytes[off] = t1.union(t2)
+			changed = changed || !t1.covers(t2)
+		}
+	}
+	var storageChanged, transientChanged bool
+	j.storage, storageChanged = joinTaintStorage(s1.storage, s2.storage)
+	j.transient, transientChanged = joinTaintStorage(s1.transient, s2.transient)
+	return j, changed || storageChanged || transientChanged
+}
+
+// constArg returns the value of the stack element at the given index if it is a (reasonably small) constant.
+func constArg(st absState, idx int) (uint64, bool) {
+	if st.isBot || st.stack.isTop || st.stack.stack == nil || st.stack.len() <= idx {
+		return 0, false
+	}
+	v := st.stack.stack.Back(idx)
+	if isTop(v) || !v.IsUint64() || maxTaintedOffset <= v.Uint64() {
+		return 0, false
+	}
+	return v.Uint64(), true
+}
+
+// constSlot returns the value of the stack element at the given index if it is a constant (e.g., a storage slot).
+func constSlot(st absState, idx int) (common.Hash, bool) {
+	if st.isBot || st.stack.isTop || st.stack.stack == nil || st.stack.len() <= idx || isTop(st.stack.stack.Back(idx)) {
+		return common.Hash{}, false
+	}
+	return common.BigToHash(st.stack.stack.Back(idx)), true
+}
+
+// transferTaint computes the taint after executing the given instruction in the given abstract state, which
+// provides the values of offsets. For a conditional jump, it also returns the taint of the condition.
+func transferTaint(op vm.OpCode, conc vm.Operation, st absState, in taintState) (taintState, taintSet, bool) {
+	out := in.clone()
+	s := &out.stack
+	switch {
+	case vm.PUSH1 <= op && op <= vm.PUSH32:
+		s.push(taintSet{})
+	case vm.DUP1 <= op && op <= vm.DUP16:
+		s.push(s.back(int(op - vm.DUP1)))
+	case vm.SWAP1 <= op && op <= vm.SWAP16:
+		s.swap(int(op-vm.SWAP1) + 1)
+	case op == vm.JUMPI:
+		cond := s.back(1)
+		s.pop()
+		s.pop()
+		return out, cond, true
+	case op == vm.CALLDATALOAD:
+		t := s.pop()
+		if off, ok := constArg(st, 0); ok {
+			s.push(t.union(calldataTaint(off, 32)))
+		} else {
+			s.push(t.union(taintSet{anyCalldata: true}))
+		}
+	case op == vm.CALLDATASIZE:
+		s.push(taintSet{calldataSize: true})
+	case op == vm.CALLVALUE:
+		s.push(taintSet{callValue: true})
+	case op == vm.CALLER:
+		s.push(taintSet{caller: true})
+	case op == vm.CALLDATACOPY:
+		s.pop()
+		s.pop()
+		s.pop()
+		memOff, ok1 := constArg(st, 0)
+		dataOff, ok2 := constArg(st, 1)
+		size, ok3 := constArg(st, 2)
+		if ok1 && ok2 && ok3 && size <= maxTaintedCopySize {
+			for i := uint64(0); i < size; i++ {
+				out.mem.bytes[memOff+i] = calldataTaint(dataOff+i, 1)
+			}
+		} else {
+			out.mem.any = out.mem.any.union(taintSet{anyCalldata: true})
+		}
+	case op == vm.SLOAD:
+		// The value may come from the initial storage or from an earlier write.
+		t := s.pop()
+		if slot, ok := constSlot(st, 0); ok {
+			s.push(t.union(taintSet{slots: map[common.Hash]bool{slot: true}}).union(out.storage.load(slot)))
+		} else {
+			s.push(t.union(taintSet{anySlot: true}).union(out.storage.loadAll()))
+		}
+	case op == vm.TLOAD:
+		t := s.pop()
+		if slot, ok := constSlot(st, 0); ok {
+			s.push(t.union(out.transient.load(slot)))
+		} else {
+			s.push(t.union(out.transient.loadAll()))
+		}
+	case op == vm.SSTORE || op == vm.TSTORE:
+		s.pop()
+		t := s.pop()
+		storage := &out.storage
+		if op == vm.TSTORE {
+			storage = &out.transient
+		}
+		if slot, ok := constSlot(st, 0); ok {
+			storage.slots[slot] = t
+		} else {
+			storage.any = storage.any.union(t)
+		}
+	case op == vm.MCOPY:
+		s.pop()
+		s.pop()
+		s.pop()
+		dst, ok1 := constArg(st, 0)
+		src, ok2 := constArg(st, 1)
+		size, ok3 := constArg(st, 2)
+		if ok1 && ok2 && ok3 && size <= maxTaintedCopySize {
+			// The source is read before writing since the areas may overlap.
+			copied := make([]taintSet, size)
+			for i := range copied {
+				copied[i] = out.mem.load(src+uint64(i), 1)
+			}
+			for i, t := range copied {
+				out.mem.bytes[dst+uint64(i)] = t
+			}
+		} else {
+			out.mem.any = out.mem.any.union(out.mem.loadAll())
+		}
+	case op == vm.MLOAD:
+		t := s.pop()
+		if off, ok := constArg(st, 0); ok {
+			s.push(t.union(out.mem.load(off, 32)))
+		} else {
+			s.push(t.union(out.mem.loadAll()))
+		}
+	case op == vm.MSTORE || op == vm.MSTORE8:
+		s.pop()
+		t := s.pop()
+		size := uint64(32)
+		if op == vm.MSTORE8 {
+			size = 1
+		}
+		if off, ok := constArg(st, 0); ok {
+			out.mem.store(off, size, t)
+		} else {
+			out.mem.any = out.mem.any.union(t)
+		}
+	case op == vm.SHA3:
+		t := s.pop().union(s.pop())
+		off, ok1 := constArg(st, 0)
+		size, ok2 := constArg(st, 1)
+		if ok1 && ok2 && size <= maxTaintedCopySize {
+			s.push(t.union(out.mem.load(off, size)))
+		} else {
+			s.push(t.union(out.mem.loadAll()))
+		}
+	default:
+		// Results depend on all arguments.
+		pushes := int(params.StackLimit) + conc.MinStack - conc.MaxStack
+		var t taintSet
+		for i := 0; i < conc.MinStack; i++ {
+			t = t.union(s.pop())
+		}
+		for i := 0; i < pushes; i++ {
+			s.push(t)
+		}
+	}
+	return out, taintSet{}, false
+}
+
+// taintAnalysis determines which inputs influence the conditions of conditional jumps.
+type taintAnalysis struct {
+	// prefix is the taint at the end of the prefix.
+	prefix taintState
+	// conds contains the taint of the conditions of conditional jumps that are reachable after the prefix.
+	conds map[pcType]taintSet
+}
+
+func newTaintAnalysis() *taintAnalysis {
+	return &taintAnalysis{
+		prefix: emptyTaintState(),
+		conds:  map[pcType]taintSet{},
+	}
+}
+
+// stepPrefix computes the taint after the given prefix instruction.
+func (t *taintAnalysis) stepPrefix(op vm.OpCode, conc vm.Operation, st absState) {
+	t.prefix, _, _ = transferTaint(op, conc, st, t.prefix)
+}
+
+// analyzeSuffix computes the taint of the states of a collecting exploration, starting from the taint at the end of
+// the prefix.
+func (t *taintAnalysis) analyzeSuffix(f *fixpoint) error {
+	a := f.analyzer
+	in := map[string]taintState{}
+	var worklist []string
+	for loc := range f.entryLocs {
+		in[loc] = t.prefix.clone()
+		worklist = append(worklist, loc)
+	}
+	for 0 < len(worklist) {
+		exceeded, err := a.budget.step(len(in))
+		if err != nil {
+			return err
+		}
+		if exceeded {
+			return fmt.Errorf("analysis exceeded its budget")
+		}
+		loc := worklist[0]
+		worklist = worklist[1:]
+		pc := f.locPCs[loc]
+		if a.layout.IsData(uint64(pc)) {
+			continue
+		}
+		op := a.contract.GetOp(uint64(pc))
+		out, cond, isBranch := transferTaint(op, f.concJt[op], f.states[loc], in[loc])
+		if isBranch {
+			t.conds[pc] = t.conds[pc].union(cond)
+		}
+		for succ := range f.succs[loc] {
+			old, exists := in[succ]
+			next := out
+			if exists {
+				var changed bool
+				if next, changed = joinTaintStates(old, out); !changed {
+					continue
+				}
+			}
+			in[succ] = next
+			worklist = append(worklist, succ)
+		}
+	}
+	return nil
+}
+
+// branchInputs returns the inputs of the conditional jumps (ordered by PC).
+func (t *taintAnalysis) branchInputs() []BranchInputs {
+	var branches []BranchInputs
+	for pc, cond := range t.conds {
+		b := BranchInputs{
+			PC:           uint64(pc),
+			AnyCalldata:  cond.anyCalldata,
+			CalldataSize: cond.calldataSize,
+			CallValue:    cond.callValue,
+			Caller:       cond.caller,
+			AnyStorage:   cond.anySlot,
+		}
+		for off := range cond.calldata {
+			b.CalldataBytes = append(b.CalldataBytes, off)
+		}
+		sort.Slice(b.CalldataBytes, func(i, j int) bool {
+			return b.CalldataBytes[i] < b.CalldataBytes[j]
+		})
+		for slot := range cond.slots {
+			b.StorageSlots = append(b.StorageSlots, slot)
+		}
+		sort.Slice(b.StorageSlots, func(i, j int) bool {
+			return bytes.Compare(b.StorageSlots[i][:], b.StorageSlots[j][:]) < 0
+		})
+		branches = append(branches, b)
+	}
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].PC < branches[j].PC
+	})
+	return branches
+}
+
+// BranchInputs returns the inputs that influence the conditional jumps that may be reached after the given prefix.
+// It returns false if some paths could not be followed (e.g., jumps to unknown destinations or a possible failure in
+// the prefix).
+func (a *constPropAnalyzer) BranchInputs(execPrefix execPrefix) ([]BranchInputs, bool, error) {
+	// Checkpoints do not contain taint, so the prefix is always analyzed from the start.
+	a.checkpointRoot = nil
+	a.taint = newTaintAnalysis()
+	concJt := a.interpreter.Cfg.JumpTable
+	prefixRes, err := a.calculatePrecondition(concJt, a.newAbsJumpTable(true), execPrefix)
+	if err != nil {
+		return nil, false, err
+	}
+	if prefixRes.mayFail && prefixRes.failureCause == BudgetExceededFail {
+		return nil, false, fmt.Errorf("analysis exceeded its budget")
+	}
+	if prefixRes.mayFail {
+		return nil, false, nil
+	}
+
+	fp := a.newFixpoint(concJt, a.newAbsJumpTable(false))
+	fp.collect = true
+	if prefixLen := len(execPrefix); prefixLen == 0 {
+		fp.addNewStates(nil, prefixRes.postStates)
+	} else {
+		lastPrefixPC := execPrefix[prefixLen-1]
+		fp.addNewStates(&lastPrefixPC, prefixRes.postStates)
+	}
+	res, err := fp.run()
+	if err != nil {
+		return nil, false, err
+	}
+	if res.mayFail && res.failureCause == BudgetExceededFail {
+		return nil, false, fmt.Errorf("analysis exceeded its budget")
+	}
+	if err := a.taint.analyzeSuffix(fp); err != nil {
+		return nil, false, err
+	}
+	return a.taint.branchInputs(), !fp.incomplete, nil
+}
@@ -0,0 +1,299 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// PredicateKind is the kind of comparison performed by a stack predicate.
+type PredicateKind int
+
+const (
+	// PredEq holds if the stack element is equal to the value.
+	PredEq PredicateKind = iota
+	// PredNe holds if the stack element is different from the value.
+	PredNe
+	// PredNonConstant holds if the stack element is not a constant (i.e., it may depend on the input).
+	PredNonConstant
+)
+
+// StackPredicate is a predicate on an element of the stack before executing an instruction.
+type StackPredicate struct {
+	// Index is the position of the stack element (0 is the top of the stack).
+	Index int
+	Kind  PredicateKind
+	Value *big.Int
+}
+
+// StackEq returns a predicate that holds if the given stack element is equal to the value.
+func StackEq(index int, value *big.Int) StackPredicate {
+	return StackPredicate{Index: index, Kind: PredEq, Value: value}
+}
+
+// StackNe returns a predicate that holds if the given stack element is different from the value.
+func StackNe(index int, value *big.Int) StackPredicate {
+	return StackPredicate{Index: index, Kind: PredNe, Value: value}
+}
+
+// StackNonConstant returns a predicate that holds if the given stack element is not a constant.
+func StackNonConstant(index int) StackPredicate {
+	return StackPredicate{Index: index, Kind: PredNonConstant}
+}
+
+// maySatisfy determines if the given abstract stack may satisfy the predicate.
+func (p StackPredicate) maySatisfy(stack absStack) bool {
+	if stack.isTop || stack.len() <= p.Index {
+		// We don't know anything about the stack element.
+		return true
+	}
+	val := stack.stack.Back(p.Index)
+	if isTop(val) {
+		return true
+	}
+	switch p.Kind {
+	case PredEq:
+		return val.Cmp(p.Value) == 0
+	case PredNe:
+		return val.Cmp(p.Value) != 0
+	}
+	return false
+}
+
+func (p StackPredicate) String() string {
+	switch p.Kind {
+	case PredEq:
+		return fmt.Sprintf("stack[%d]==%#x", p.Index, p.Value)
+	case PredNe:
+		return fmt.Sprintf("stack[%d]!=%#x", p.Index, p.Value)
+	}
+	return fmt.Sprintf("stack[%d]==nonconst", p.Index)
+}
+
+// Target describes behavior that should be reported as a possible failure if it may be reached.
+// A target fires at an instruction if the instruction has one of the target's opcodes (if any), is at the target's
+// location (if any), and all predicates may hold in the abstract state before the instruction.
+type Target struct {
+	// Name is used in failure causes.
+	Name       string
+	Opcodes    []vm.OpCode
+	CodeHash   *common.Hash
+	PC         *uint64
+	Predicates []StackPredicate
+}
+
+// OpcodeTarget returns a target for any instruction with the given opcode (e.g., any SELFDESTRUCT).
+func OpcodeTarget(op vm.OpCode, preds ...StackPredicate) Target {
+	return Target{
+		Name:       joinTargetName(op.String(), preds),
+		Opcodes:    []vm.OpCode{op},
+		Predicates: preds,
+	}
+}
+
+// ValueCallTarget returns a target for any call that may transfer a non-zero value.
+func ValueCallTarget() Target {
+	return Target{
+		Name:       "value-call",
+		Opcodes:    []vm.OpCode{vm.CALL, vm.CALLCODE},
+		Predicates: []StackPredicate{StackNe(2, big.NewInt(0))},
+	}
+}
+
+// StorageWriteTarget returns a target for any SSTORE that may write to the given slot.
+func StorageWriteTarget(slot *big.Int) Target {
+	return Target{
+		Name:       fmt.Sprintf("sstore=%#x", slot),
+		Opcodes:    []vm.OpCode{vm.SSTORE},
+		Predicates: []StackPredicate{StackEq(0, slot)},
+	}
+}
+
+// LocationTarget returns a target for the instruction at the given PC.
+// If the code hash is nil, the target applies to any contract.
+func LocationTarget(codeHash *common.Hash, pc uint64, preds ...StackPredicate) Target {
+	loc := fmt.Sprintf("pc=%#x", pc)
+	if codeHash != nil {
+		loc = fmt.Sprintf("pc=%x:%#x", *codeHash, pc)
+	}
+	return Target{
+		Name:       joinTargetName(loc, preds),
+		CodeHash:   codeHash,
+		PC:         &pc,
+		Predicates: preds,
+	}
+}
+
+// NonConstantCallTarget returns a target for any call whose destination is not a constant.
+func NonConstantCallTarget() Target {
+	return Target{
+		Name:       "nonconst-call",
+		Opcodes:    []vm.OpCode{vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL},
+		Predicates: []StackPredicate{StackNonConstant(1)},
+	}
+}
+
+func joinTargetName(head string, preds []StackPredicate) string {
+	parts := []string{head}
+	for _, p := range preds {
+		parts = append(parts, p.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// mayFire determines if the target may fire at the given instruction.
+func (t Target) mayFire(codeHash common.Hash, pc pcType, op vm.OpCode, st absState) bool {
+	if st.isBot {
+		return false
+	}
+	if t.PC != nil && *t.PC != uint64(pc) {
+		return false
+	}
+	if t.CodeHash != nil && *t.CodeHash != codeHash {
+		return false
+	}
+	if 0 < len(t.Opcodes) {
+		found := false
+		for _, tOp := range t.Opcodes {
+			if tOp == op {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, p := range t.Predicates {
+		if !p.maySatisfy(st.stack) {
+			return false
+		}
+	}
+	return true
+}
+
+var predicatePattern = regexp.MustCompile(`^stack\[(\d+)\](==|!=)(\S+)$`)
+
+// ParseTarget parses a target from its textual representation.
+// A target consists of a head followed by whitespace-separated stack predicates. The head is one of
+//   - an opcode name (e.g., "SELFDESTRUCT"),
+//   - "value-call" (calls that may transfer value),
+//   - "sstore=<slot>" (writes to a storage slot),
+//   - "pc=<pc>" or "pc=<code hash>:<pc>" (an instruction location, where the PC is decimal or hexadecimal with a
+//     "0x" prefix), or
+//   - "nonconst-call" (calls whose destination is not a constant).
+//
+// Predicates have the form "stack[<index>]==<value>", "stack[<index>]!=<value>" or "stack[<index>]==nonconst".
+// For instance, "CALL stack[2]!=0" targets any call with a non-zero value and "pc=0x1a3 stack[0]==0" targets the
+// instruction at PC 0x1a3 if the top of the stack may be zero.
+func ParseTarget(spec string) (Target, error) {
+	fields := strings.Fields(spec)
+	if len(fields) == 0 {
+		return Target{}, fmt.Errorf("empty target")
+	}
+	var preds []StackPredicate
+	for _, f := range fields[1:] {
+		m := predicatePattern.FindStringSubmatch(f)
+		if m == nil {
+			return Target{}, fmt.Errorf("invalid predicate '%v'", f)
+		}
+		idx, err := strconv.Atoi(m[1])
+		if err != nil {
+			return Target{}, fmt.Errorf("invalid stack index in predicate '%v'", f)
+		}
+		if m[3] == "nonconst" {
+			if m[2] != "==" {
+				return Target{}, fmt.Errorf("invalid predicate '%v'", f)
+			}
+			preds = append(preds, StackNonConstant(idx))
+			continue
+		}
+		val, ok := math.ParseBig256(m[3])
+		if !ok {
+			return Target{}, fmt.Errorf("invalid value in predicate '%v'", f)
+		}
+		if m[2] == "==" {
+			preds = append(preds, StackEq(idx, val))
+		} else {
+			preds = append(preds, StackNe(idx, val))
+		}
+	}
+
+	var t Target
+	head := fields[0]
+	switch {
+	case head == "value-call":
+		t = ValueCallTarget()
+	case head == "nonconst-call":
+		t = NonConstantCallTarget()
+	case strings.HasPrefix(head, "sstore="):
+		slot, ok := math.ParseBig256(strings.TrimPrefix(head, "sstore="))
+		if !ok {
+			return Target{}, fmt.Errorf("invalid storage slot in '%v'", head)
+		}
+		t = StorageWriteTarget(slot)
+	case strings.HasPrefix(head, "pc="):
+		loc := strings.TrimPrefix(head, "pc=")
+		var codeHash *common.Hash
+		if i := strings.LastIndex(loc, ":"); 0 <= i {
+			b, err := hex.DecodeString(strings.TrimPrefix(loc[:i], "0x"))
+			if err != nil || len(b) != common.HashLength {
+				return Target{}, fmt.Errorf("invalid code hash in '%v'", head)
+			}
+			ch := common.BytesToHash(b)
+			codeHash = &ch
+			loc = loc[i+1:]
+		}
+		// PCs are decimal unless they start with "0x" (in both forms).
+		pc, ok := math.ParseUint64(loc)
+		if !ok {
+			return Target{}, fmt.Errorf("invalid PC in '%v'", head)
+		}
+		t = LocationTarget(codeHash, pc)
+	default:
+		op := vm.StringToOp(head)
+		if op.String() != head {
+			return Target{}, fmt.Errorf("unknown opcode '%v'", head)
+		}
+		t = OpcodeTarget(op)
+	}
+	t.Predicates = append(t.Predicates, preds...)
+	t.Name = strings.Join(fields, " ")
+	return t, nil
+}
+
+// mayReachTarget determines if any of the registered targets may fire at the given instruction.
+// If so, it also returns the failure cause for the (first) matching target.
+func (a *constPropAnalyzer) mayReachTarget(pc pcType, op vm.OpCode, st absState) (string, bool) {
+	for _, t := range a.analyzer.targets {
+		if t.mayFire(a.codeHash, pc, op, st) {
+			return fmt.Sprintf("%v(%v)", ReachedTargetFail, t.Name), true
+		}
+	}
+	return "", false
+}
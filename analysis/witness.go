@@ -0,0 +1,78 @@
+// Copyright 2018 MPI-SWS, Valentin Wuestholz, and ConsenSys AG
+
+// This file is part of Bran.
+//
+// Bran is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// Bran is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with Bran.  If not, see <https://www.gnu.org/licenses/>.
+
+package analysis
+
+import (
+	"math/big"
+
+	"github.com/practical-formal-methods/bran/vm"
+)
+
+// Witness is an abstract path from the end of the prefix to a possible failure.
+// Since states are joined, the path may not be feasible (e.g., for spurious failures).
+type Witness struct {
+	// PCs contains the PCs along the path, from the first instruction after the prefix to the instruction that may
+	// fail.
+	PCs []uint64
+	// Branches contains the abstract states at the conditional jumps along the path.
+	Branches []BranchState
+}
+
+// BranchState is the abstract state before a conditional jump.
+type BranchState struct {
+	PC uint64
+	// Stack contains the stack elements from the top to the bottom (nil for unknown elements). It is nil if the
+	// whole stack is unknown.
+	Stack []*big.Int
+}
+
+// witness returns the path to the state with the given location.
+// Every state only has the predecessor that first reached it. Since the predecessor already existed at that time,
+// following predecessors always ends at an entry state.
+func (f *fixpoint) witness(loc string) *Witness {
+	var locs []string
+	for curr, exists := loc, true; exists; curr, exists = f.preds[curr] {
+		locs = append(locs, curr)
+	}
+	w := &Witness{}
+	for i := len(locs) - 1; 0 <= i; i-- {
+		pc := f.locPCs[locs[i]]
+		w.PCs = append(w.PCs, uint64(pc))
+		if f.analyzer.contract.GetOp(uint64(pc)) == vm.JUMPI {
+			w.Branches = append(w.Branches, BranchState{
+				PC:    uint64(pc),
+				Stack: concreteStack(f.states[locs[i]].stack),
+			})
+		}
+	}
+	return w
+}
+
+// concreteStack returns the elements of the given stack from the top to the bottom (nil for unknown elements).
+func concreteStack(s absStack) []*big.Int {
+	if s.isTop || s.stack == nil {
+		return nil
+	}
+	vals := make([]*big.Int, s.len())
+	for idx := range vals {
+		if v := s.stack.Back(idx); !isTop(v) {
+			vals[idx] = new(big.Int).Set(v)
+		}
+	}
+	return vals
+}
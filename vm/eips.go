@@ -19,6 +19,7 @@ package vm
 import (
 	"fmt"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/params"
 )
 
@@ -27,6 +28,18 @@ import (
 // defined jump tables are not polluted.
 func EnableEIP(eipNum int, jt *JumpTable) error {
 	switch eipNum {
+	case 7516:
+		enable7516(jt)
+	case 5656:
+		enable5656(jt)
+	case 4844:
+		enable4844(jt)
+	case 3855:
+		enable3855(jt)
+	case 3198:
+		enable3198(jt)
+	case 1153:
+		enable1153(jt)
 	case 2200:
 		enable2200(jt)
 	case 1884:
@@ -90,3 +103,160 @@ func opChainID(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memo
 func enable2200(jt *JumpTable) {
 	jt[SSTORE].dynamicGas = gasSStoreEIP2200
 }
+
+// enable3198 applies EIP-3198 (BASEFEE Opcode)
+// - Adds an opcode that returns the current block's base fee.
+func enable3198(jt *JumpTable) {
+	// New opcode
+	jt[BASEFEE] = Operation{
+		Execute:     opBaseFee,
+		constantGas: GasQuickStep,
+		MinStack:    minStack(0, 1),
+		MaxStack:    maxStack(0, 1),
+		Valid:       true,
+	}
+}
+
+// opBaseFee implements BASEFEE opcode
+func opBaseFee(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
+	baseFee := interpreter.IntPool.getZero()
+	if interpreter.evm.BaseFee != nil {
+		baseFee.Set(interpreter.evm.BaseFee)
+	}
+	stack.Push(baseFee)
+	return nil, nil
+}
+
+// enable3855 applies EIP-3855 (PUSH0 opcode)
+func enable3855(jt *JumpTable) {
+	// New opcode
+	jt[PUSH0] = Operation{
+		Execute:     opPush0,
+		constantGas: GasQuickStep,
+		MinStack:    minStack(0, 1),
+		MaxStack:    maxStack(0, 1),
+		Valid:       true,
+	}
+}
+
+// opPush0 implements the PUSH0 opcode
+func opPush0(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
+	stack.Push(interpreter.IntPool.getZero())
+	return nil, nil
+}
+
+// warmStorageReadCostEIP2929 is the cost of reading warm storage, which is also the cost of accessing transient storage.
+const warmStorageReadCostEIP2929 uint64 = 100
+
+// enable1153 applies EIP-1153 "Transient Storage"
+// - Adds TLOAD that reads from transient storage
+// - Adds TSTORE that writes to transient storage
+func enable1153(jt *JumpTable) {
+	jt[TLOAD] = Operation{
+		Execute:     opTload,
+		constantGas: warmStorageReadCostEIP2929,
+		MinStack:    minStack(1, 1),
+		MaxStack:    maxStack(1, 1),
+		Valid:       true,
+	}
+
+	jt[TSTORE] = Operation{
+		Execute:     opTstore,
+		constantGas: warmStorageReadCostEIP2929,
+		MinStack:    minStack(2, 0),
+		MaxStack:    maxStack(2, 0),
+		Valid:       true,
+		writes:      true,
+	}
+}
+
+// opTload implements TLOAD opcode
+func opTload(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
+	loc := stack.Peek()
+	val := interpreter.evm.GetTransientState(contract.Address(), common.BigToHash(loc))
+	loc.SetBytes(val.Bytes())
+	return nil, nil
+}
+
+// opTstore implements TSTORE opcode
+func opTstore(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
+	loc := common.BigToHash(stack.Pop())
+	val := stack.Pop()
+	interpreter.evm.SetTransientState(contract.Address(), loc, common.BigToHash(val))
+
+	interpreter.IntPool.put(val)
+	return nil, nil
+}
+
+// enable5656 enables EIP-5656 (MCOPY opcode)
+// https://eips.ethereum.org/EIPS/eip-5656
+func enable5656(jt *JumpTable) {
+	jt[MCOPY] = Operation{
+		Execute:     opMcopy,
+		constantGas: GasFastestStep,
+		dynamicGas:  gasMcopy,
+		MinStack:    minStack(3, 0),
+		MaxStack:    maxStack(3, 0),
+		MemorySize:  memoryMcopy,
+		Valid:       true,
+	}
+}
+
+// opMcopy implements the MCOPY opcode (https://eips.ethereum.org/EIPS/eip-5656)
+func opMcopy(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
+	var (
+		dst    = stack.Pop()
+		src    = stack.Pop()
+		length = stack.Pop()
+	)
+	// These values are checked for overflow during memory expansion calculation
+	// (the memorySize function on the opcode).
+	memory.Copy(dst.Uint64(), src.Uint64(), length.Uint64())
+
+	interpreter.IntPool.put(dst, src, length)
+	return nil, nil
+}
+
+// enable4844 applies EIP-4844 (BLOBHASH opcode)
+func enable4844(jt *JumpTable) {
+	jt[BLOBHASH] = Operation{
+		Execute:     opBlobHash,
+		constantGas: GasFastestStep,
+		MinStack:    minStack(1, 1),
+		MaxStack:    maxStack(1, 1),
+		Valid:       true,
+	}
+}
+
+// opBlobHash implements the BLOBHASH opcode
+func opBlobHash(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
+	index := stack.Peek()
+	if index.IsUint64() && index.Uint64() < uint64(len(interpreter.evm.BlobHashes)) {
+		blobHash := interpreter.evm.BlobHashes[index.Uint64()]
+		index.SetBytes(blobHash.Bytes())
+	} else {
+		index.SetUint64(0)
+	}
+	return nil, nil
+}
+
+// enable7516 applies EIP-7516 (BLOBBASEFEE opcode)
+func enable7516(jt *JumpTable) {
+	jt[BLOBBASEFEE] = Operation{
+		Execute:     opBlobBaseFee,
+		constantGas: GasQuickStep,
+		MinStack:    minStack(0, 1),
+		MaxStack:    maxStack(0, 1),
+		Valid:       true,
+	}
+}
+
+// opBlobBaseFee implements BLOBBASEFEE opcode
+func opBlobBaseFee(pc *uint64, interpreter *EVMInterpreter, contract *Contract, memory *Memory, stack *Stack) ([]byte, error) {
+	blobBaseFee := interpreter.IntPool.getZero()
+	if interpreter.evm.BlobBaseFee != nil {
+		blobBaseFee.Set(interpreter.evm.BlobBaseFee)
+	}
+	stack.Push(blobBaseFee)
+	return nil, nil
+}
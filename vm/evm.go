@@ -91,6 +91,9 @@ type Context struct {
 	BlockNumber *big.Int       // Provides information for NUMBER
 	Time        *big.Int       // Provides information for TIME
 	Difficulty  *big.Int       // Provides information for DIFFICULTY
+	BaseFee     *big.Int       // Provides information for BASEFEE (0 if nil)
+	BlobBaseFee *big.Int       // Provides information for BLOBBASEFEE (0 if nil)
+	BlobHashes  []common.Hash  // Provides information for BLOBHASH
 }
 
 // EVM is the Ethereum Virtual Machine base object and provides
@@ -128,6 +131,9 @@ type EVM struct {
 	// available gas is calculated in gasCall* according to the 63/64 rule and later
 	// applied in opCall*.
 	callGasTemp uint64
+	// transientStorage holds the transient storage (EIP-1153) of all accounts.
+	// It is discarded at the end of the transaction, which is when the EVM is discarded.
+	transientStorage transientStorage
 }
 
 // NewEVM returns a new EVM. The returned EVM is not thread safe and should
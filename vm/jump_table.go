@@ -60,15 +60,91 @@ var (
 	spuriousDragonInstructionSet   = newSpuriousDragonInstructionSet()
 	byzantiumInstructionSet        = newByzantiumInstructionSet()
 	constantinopleInstructionSet   = NewConstantinopleInstructionSet()
-	istanbulInstructionSet         = newIstanbulInstructionSet()
+	istanbulInstructionSet         = NewIstanbulInstructionSet()
 )
 
 // JumpTable contains the EVM opcodes supported at a given fork.
 type JumpTable [256]Operation
 
-// newIstanbulInstructionSet returns the frontier, homestead
+// OperationSpec describes an operation that is not part of any fork (e.g., an opcode of a custom chain).
+type OperationSpec struct {
+	Execute     ExecutionFunc
+	ConstantGas uint64
+	// DynamicGas computes the gas in addition to the constant gas (optional).
+	DynamicGas func(evm *EVM, contract *Contract, stack *Stack, mem *Memory, memorySize uint64) (uint64, error)
+	// Pops and Pushes are the number of stack elements that the operation consumes and produces.
+	Pops   int
+	Pushes int
+	// MemorySize returns the required memory size (optional).
+	MemorySize MemorySizeFunc
+	Halts      bool
+	Jumps      bool
+	Writes     bool
+	Reverts    bool
+	Returns    bool
+}
+
+// NewOperation creates a valid operation from the given specification.
+func NewOperation(spec OperationSpec) Operation {
+	return Operation{
+		Execute:     spec.Execute,
+		constantGas: spec.ConstantGas,
+		dynamicGas:  spec.DynamicGas,
+		MinStack:    minStack(spec.Pops, spec.Pushes),
+		MaxStack:    maxStack(spec.Pops, spec.Pushes),
+		MemorySize:  spec.MemorySize,
+		halts:       spec.Halts,
+		jumps:       spec.Jumps,
+		writes:      spec.Writes,
+		Valid:       true,
+		reverts:     spec.Reverts,
+		returns:     spec.Returns,
+	}
+}
+
+// NewCancunInstructionSet returns the frontier, homestead, byzantium,
+// contantinople, istanbul, london, shanghai and cancun instructions.
+func NewCancunInstructionSet() JumpTable {
+	instructionSet := NewShanghaiInstructionSet()
+
+	enable4844(&instructionSet) // BLOBHASH opcode - https://eips.ethereum.org/EIPS/eip-4844
+	enable7516(&instructionSet) // BLOBBASEFEE opcode - https://eips.ethereum.org/EIPS/eip-7516
+	enable1153(&instructionSet) // Transient storage opcodes - https://eips.ethereum.org/EIPS/eip-1153
+	enable5656(&instructionSet) // MCOPY opcode - https://eips.ethereum.org/EIPS/eip-5656
+
+	return instructionSet
+}
+
+// NewShanghaiInstructionSet returns the frontier, homestead, byzantium,
+// contantinople, istanbul, london and shanghai instructions.
+func NewShanghaiInstructionSet() JumpTable {
+	instructionSet := NewLondonInstructionSet()
+
+	enable3855(&instructionSet) // PUSH0 instruction - https://eips.ethereum.org/EIPS/eip-3855
+
+	return instructionSet
+}
+
+// NewLondonInstructionSet returns the frontier, homestead, byzantium,
+// contantinople, istanbul, berlin and london instructions.
+func NewLondonInstructionSet() JumpTable {
+	instructionSet := NewBerlinInstructionSet()
+
+	enable3198(&instructionSet) // Base fee opcode - https://eips.ethereum.org/EIPS/eip-3198
+
+	return instructionSet
+}
+
+// NewBerlinInstructionSet returns the frontier, homestead, byzantium,
+// contantinople, istanbul and berlin instructions.
+// Berlin did not add any instructions and its gas changes (EIP-2929) are not modeled.
+func NewBerlinInstructionSet() JumpTable {
+	return NewIstanbulInstructionSet()
+}
+
+// NewIstanbulInstructionSet returns the frontier, homestead
 // byzantium, contantinople and petersburg instructions.
-func newIstanbulInstructionSet() JumpTable {
+func NewIstanbulInstructionSet() JumpTable {
 	instructionSet := NewConstantinopleInstructionSet()
 
 	enable1344(&instructionSet) // ChainID opcode - https://eips.ethereum.org/EIPS/eip-1344
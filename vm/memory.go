@@ -48,6 +48,18 @@ func (m *Memory) Set(offset, size uint64, value []byte) {
 	}
 }
 
+// Copy copies size bytes from the source offset to the destination offset (the areas may overlap).
+func (m *Memory) Copy(dst, src, size uint64) {
+	if size > 0 {
+		// length of store may never be less than offset + size.
+		// The store should be resized PRIOR to copying the memory
+		if dst+size > uint64(len(m.store)) || src+size > uint64(len(m.store)) {
+			panic("invalid memory: store empty")
+		}
+		copy(m.store[dst:dst+size], m.store[src:src+size])
+	}
+}
+
 // Set32 sets the 32 bytes starting at offset to the value of val, left-padded with zeroes to
 // 32 bytes.
 func (m *Memory) Set32(offset uint64, val *big.Int) {
@@ -36,6 +36,21 @@ func memoryExtCodeCopy(stack *Stack) (uint64, bool) {
 	return calcMemSize64(stack.Back(1), stack.Back(3))
 }
 
+func memoryMcopy(stack *Stack) (uint64, bool) {
+	dstSize, overflow := calcMemSize64(stack.Back(0), stack.Back(2))
+	if overflow {
+		return 0, true
+	}
+	srcSize, overflow := calcMemSize64(stack.Back(1), stack.Back(2))
+	if overflow {
+		return 0, true
+	}
+	if dstSize < srcSize {
+		return srcSize, false
+	}
+	return dstSize, false
+}
+
 func memoryMLoad(stack *Stack) (uint64, bool) {
 	return calcMemSize64WithUint(stack.Back(0), 32)
 }
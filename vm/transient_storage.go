@@ -0,0 +1,54 @@
+// Copyright 2022 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package vm
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// transientStorage is a representation of EIP-1153 "Transient Storage".
+type transientStorage map[common.Address]map[common.Hash]common.Hash
+
+// set sets the transient-storage `value` for `key` at the given `addr`.
+func (t *transientStorage) set(addr common.Address, key, value common.Hash) {
+	if *t == nil {
+		*t = transientStorage{}
+	}
+	if _, ok := (*t)[addr]; !ok {
+		(*t)[addr] = make(map[common.Hash]common.Hash)
+	}
+	(*t)[addr][key] = value
+}
+
+// get gets the transient storage for `key` at the given `addr`.
+func (t transientStorage) get(addr common.Address, key common.Hash) common.Hash {
+	val, ok := t[addr]
+	if !ok {
+		return common.Hash{}
+	}
+	return val[key]
+}
+
+// GetTransientState returns the transient storage of the given account.
+func (evm *EVM) GetTransientState(addr common.Address, key common.Hash) common.Hash {
+	return evm.transientStorage.get(addr, key)
+}
+
+// SetTransientState sets the transient storage of the given account.
+func (evm *EVM) SetTransientState(addr common.Address, key, value common.Hash) {
+	evm.transientStorage.set(addr, key, value)
+}